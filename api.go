@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxAPISubscriberBacklog is the number of events buffered for a slow /api/events client before its events are
+	// dropped.
+	MaxAPISubscriberBacklog = 100
+	APIEventSummary         = "summary"
+	APIEventSyscall         = "syscall"
+	APIEventLifecycle       = "lifecycle"
+)
+
+// APIServer serves the summaries of the target process as JSON, and streams them at each sampling interval along with
+// the individual syscall and lifecycle events as server-sent events.
+type APIServer struct {
+	PID  int
+	BPF  *BpfTracer
+	Proc *ProcInfo
+	// Identity holds the identity labels of the process, such as its container and pod.
+	Identity map[string]string
+
+	mutex       *sync.Mutex
+	subscribers map[chan APIEvent]struct{}
+}
+
+type APIEvent struct {
+	Name string
+	Data any
+}
+
+type APIProcess struct {
+	PID       int
+	PPID      int
+	Comm      string
+	Exe       string
+	CWD       string
+	State     string
+	Threads   int
+	StartedAt time.Time
+	RSSBytes  uint64
+	UID, GID  uint64
+	FDs       map[int]string
+	Identity  map[string]string
+}
+
+// APIHierarchyMember is the main thread of a process related to the target, such as its session leader and parent.
+type APIHierarchyMember struct {
+	Role     string
+	PID      int
+	Comm     string
+	State    string
+	UID, GID uint64
+}
+
+type APISummary struct {
+	Time                time.Time
+	SamplingIntervalSec int
+	Process             APIProcess
+	Hierarchy           []APIHierarchyMember
+	// ThreadStates counts the threads of the target by their state, such as R for running and S for sleeping.
+	ThreadStates    map[string]int
+	Files           []*FileIOCounter
+	BlockDevices    []*BlockIOCounter
+	TCPSources      []BpfNetIOTrafficCounter
+	TCPDestinations []BpfNetIOTrafficCounter
+	Syscalls        []*SyscallCounter
+}
+
+func NewAPIServer(pid int, procInfo *ProcInfo, bpf *BpfTracer, identity map[string]string) *APIServer {
+	return &APIServer{
+		PID:         pid,
+		BPF:         bpf,
+		Proc:        procInfo,
+		Identity:    identity,
+		mutex:       new(sync.Mutex),
+		subscribers: make(map[chan APIEvent]struct{}),
+	}
+}
+
+// Attach streams the events of the tracer in addition to its existing event handlers, and starts streaming the
+// summary at each sampling interval.
+func (api *APIServer) Attach(bpf *BpfTracer) {
+	onSyscallEvent := bpf.OnSyscallEvent
+	bpf.OnSyscallEvent = func(evt SyscallEvent) {
+		api.publish(APIEvent{Name: APIEventSyscall, Data: evt})
+		if onSyscallEvent != nil {
+			onSyscallEvent(evt)
+		}
+	}
+	onLifecycleEvent := bpf.OnLifecycleEvent
+	bpf.OnLifecycleEvent = func(evt LifecycleEvent) {
+		api.publish(APIEvent{Name: APIEventLifecycle, Data: evt})
+		if onLifecycleEvent != nil {
+			onLifecycleEvent(evt)
+		}
+	}
+	go func() {
+		for range time.Tick(time.Duration(bpf.SamplingIntervalSec) * time.Second) {
+			api.mutex.Lock()
+			subscribed := len(api.subscribers) > 0
+			api.mutex.Unlock()
+			if subscribed {
+				api.publish(APIEvent{Name: APIEventSummary, Data: api.Summary()})
+			}
+		}
+	}()
+}
+
+func (api *APIServer) publish(evt APIEvent) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	for subscriber := range api.subscribers {
+		select {
+		case subscriber <- evt:
+		default:
+		}
+	}
+}
+
+// Summary returns the latest sampling interval of the file, block device, TCP, and syscall activities.
+func (api *APIServer) Summary() *APISummary {
+	api.Proc.Mutex.RLock()
+	defer api.Proc.Mutex.RUnlock()
+	target := api.Proc.TargetInfo
+	ret := &APISummary{
+		Time: time.Now(),
+		Process: APIProcess{
+			PID:       api.PID,
+			PPID:      target.MainStat.PPID,
+			Comm:      target.MainComm,
+			Exe:       target.MainExec,
+			CWD:       target.MainCWD,
+			State:     target.MainStat.State,
+			Threads:   len(target.Threads),
+			StartedAt: time.Now().Add(-(api.Proc.Uptime - time.Duration(target.StartSecSinceBoot)*time.Second)).Round(time.Second),
+			RSSBytes:  target.MainStatus.VmRSS,
+			UID:       target.MainStatus.UIDs[0],
+			GID:       target.MainStatus.GIDs[0],
+			FDs:       target.FDPath,
+			Identity:  api.Identity,
+		},
+	}
+	ret.Hierarchy = api.hierarchy()
+	ret.ThreadStates = make(map[string]int)
+	for _, stat := range target.Stat {
+		ret.ThreadStates[stat.State]++
+	}
+	api.BPF.mutex.Lock()
+	defer api.BPF.mutex.Unlock()
+	ret.SamplingIntervalSec = api.BPF.SamplingIntervalSec
+	ret.Files = api.BPF.FileIOSummary(target.FDPath, target.FDFileID).ByRate
+	ret.BlockDevices = api.BPF.BlockIOSummary(api.Proc.DiskStats).ByDuration
+	ret.TCPSources = append([]BpfNetIOTrafficCounter{}, api.BPF.TcpTrafficSources...)
+	ret.TCPDestinations = append([]BpfNetIOTrafficCounter{}, api.BPF.TcpTrafficDestinations...)
+	ret.Syscalls = api.BPF.SyscallSummary().ByCount
+	return ret
+}
+
+// hierarchy lists the relatives of the target from the outermost to the target itself, in the order of the overview
+// panel.
+func (api *APIServer) hierarchy() []APIHierarchyMember {
+	member := func(role string, info *ProcessInfo) APIHierarchyMember {
+		return APIHierarchyMember{
+			Role:  role,
+			PID:   info.MainStat.PID,
+			Comm:  info.MainComm,
+			State: info.MainStat.State,
+			UID:   info.MainStatus.UIDs[0],
+			GID:   info.MainStatus.GIDs[0],
+		}
+	}
+	ret := []APIHierarchyMember{member("Session", api.Proc.SessionInfo)}
+	if api.Proc.TTYGroupInfo.PID > 0 {
+		ret = append(ret, member("TTY group", api.Proc.TTYGroupInfo))
+	}
+	if api.Proc.ParentInfo.MainStat.PID < api.Proc.GroupInfo.MainStat.PID {
+		ret = append(ret, member("Parent", api.Proc.ParentInfo), member("Group", api.Proc.GroupInfo))
+	} else {
+		ret = append(ret, member("Group", api.Proc.GroupInfo), member("Parent", api.Proc.ParentInfo))
+	}
+	return append(ret, member("Target", api.Proc.TargetInfo))
+}
+
+func (api *APIServer) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/summary", api.handleSummary)
+	mux.HandleFunc("/api/events", api.handleEvents)
+	api.registerDashboard(mux)
+}
+
+func (api *APIServer) handleSummary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(api.Summary()); err != nil {
+		log.Printf("Failed to write the API summary: %v", err)
+	}
+}
+
+// handleEvents streams the events as server-sent events, each named by its kind and carrying JSON data.
+func (api *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	subscriber := make(chan APIEvent, MaxAPISubscriberBacklog)
+	api.mutex.Lock()
+	api.subscribers[subscriber] = struct{}{}
+	api.mutex.Unlock()
+	defer func() {
+		api.mutex.Lock()
+		delete(api.subscribers, subscriber)
+		api.mutex.Unlock()
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case evt := <-subscriber:
+			data, err := json.Marshal(evt.Data)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Name, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
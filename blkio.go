@@ -2,22 +2,35 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/prometheus/procfs/blockdevice"
 )
 
 type BlkdevModel struct {
-	PID       int
-	BPF       *BpfTracer
-	Proc      *ProcInfo
-	TermWidth int
+	PID     int
+	BPF     *BpfTracer
+	Proc    *ProcInfo
+	History *History
+	Width   int
+	Height  int
+	Cursor  RowCursor
+	Sort    SortOrder
+	Filter  RowFilter
 }
 
-func NewBlkdevModel(pid int, procInfo *ProcInfo, bpf *BpfTracer) *BlkdevModel {
-	return &BlkdevModel{PID: pid, Proc: procInfo, BPF: bpf}
+const (
+	BlkdevSortDuration = "duration"
+	BlkdevSortSectors  = "sectors"
+)
+
+func NewBlkdevModel(pid int, procInfo *ProcInfo, bpf *BpfTracer, history *History) *BlkdevModel {
+	return &BlkdevModel{PID: pid, Proc: procInfo, BPF: bpf, History: history, Sort: NewSortOrder(BlkdevSortDuration, BlkdevSortSectors)}
 }
 
 func (model *BlkdevModel) Init() tea.Cmd {
@@ -27,19 +40,17 @@ func (model *BlkdevModel) Init() tea.Cmd {
 func (model *BlkdevModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.String() {
-		case tea.KeyEnter.String():
-			log.Printf("@@@@@ got enter")
-		}
-	case tea.WindowSizeMsg:
-		model.TermWidth = msg.Width
+		model.Cursor.Update(msg)
+		model.Sort.Update(msg)
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
 	}
 	return model, nil
 }
 
 func (model *BlkdevModel) GetRegularStyle() lipgloss.Style {
 	return lipgloss.NewStyle().
-		Width(model.TermWidth/2-2).Height(15).Align(lipgloss.Left, lipgloss.Top).
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
 		BorderStyle(lipgloss.RoundedBorder())
 }
 
@@ -49,22 +60,168 @@ func (model *BlkdevModel) GetFocusedStyle() lipgloss.Style {
 		BorderBackground(lipgloss.Color(FocusedBorderBackground))
 }
 
+func (model *BlkdevModel) GetFilter() *RowFilter {
+	return &model.Filter
+}
+
+var sparklineBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// HistogramCaption renders the non-empty range of a microsecond histogram as a sparkline, labelled by its boundaries.
+func HistogramCaption(buckets []BpfHistBucket) string {
+	first, last, maxCount := -1, -1, 0
+	for i, bucket := range buckets {
+		if bucket.Count == 0 || bucket.Min == nil || bucket.Max == nil {
+			continue
+		}
+		if first == -1 {
+			first = i
+		}
+		last = i
+		maxCount = max(maxCount, bucket.Count)
+	}
+	if first == -1 {
+		return ""
+	}
+	var sparkline []rune
+	for _, bucket := range buckets[first : last+1] {
+		if bucket.Count == 0 {
+			sparkline = append(sparkline, ' ')
+			continue
+		}
+		sparkline = append(sparkline, sparklineBlocks[(bucket.Count*(len(sparklineBlocks)-1))/maxCount])
+	}
+	return fmt.Sprintf("%v %s %v",
+		time.Duration(*buckets[first].Min)*time.Microsecond, string(sparkline), time.Duration(*buckets[last].Max)*time.Microsecond)
+}
+
 func (model *BlkdevModel) View() string {
-	var ret string
-	ret += genericLabel.Render("Block device IO activities") + "\n"
-	blkdevs := model.BPF.BlockIOSummary(model.Proc.DiskStats)
-	if len(blkdevs.ByDuration) == 0 {
+	blkdevs := model.sortedBlkdevs()
+	var rowNames []string
+	for _, blkdev := range blkdevs {
+		rowNames = append(rowNames, blkdev.DeviceName)
+	}
+	// Each device takes up to four lines, leave room for the top files.
+	from, to := model.Cursor.SetRows(rowNames, (model.Height-9)/4)
+	ret := ListHeader("Block device IO activities", model.Sort, model.Cursor, model.Filter) + "\n"
+	writeback := model.BPF.WritebackSummary()
+	if writeback.DirtiedBytes+writeback.WrittenBackBytes > 0 || writeback.ThrottleTime > 0 {
+		ret += fmt.Sprintf("Dirtied %-8s written back %-8s throttled %v/s\n",
+			IORateCaption(writeback.DirtiedBytes/model.BPF.SamplingIntervalSec),
+			IORateCaption(writeback.WrittenBackBytes/model.BPF.SamplingIntervalSec),
+			(writeback.ThrottleTime / time.Duration(model.BPF.SamplingIntervalSec)).Round(time.Millisecond))
+	}
+	if len(blkdevs) == 0 {
 		ret += "No data yet."
 		return ret
 	}
-	for i, blkdev := range blkdevs.ByDuration {
-		if i == 12 {
-			break
+	for i := from; i < to; i++ {
+		blkdev := blkdevs[i]
+		read, write := blkdev.Op(BlockIORead), blkdev.Op(BlockIOWrite)
+		ret += model.Cursor.Render(i, fmt.Sprintf("%-12s %s R %-14s W %s",
+			PathCaption(blkdev.DeviceName, 12),
+			model.History.Sparkline(HistoryKey(HistoryBlkdev, blkdev.DeviceName), SparklineWidth(model.Width, 46)),
+			fmt.Sprintf("%d sectors/s", read.SectorCount/model.BPF.SamplingIntervalSec),
+			fmt.Sprintf("%d sectors/s", write.SectorCount/model.BPF.SamplingIntervalSec))) + "\n"
+		ret += fmt.Sprintf("  queue  R %-14v W %v\n", read.AvgQueueTime().Round(time.Microsecond), write.AvgQueueTime().Round(time.Microsecond))
+		ret += fmt.Sprintf("  device R %-14v W %v\n", read.AvgServiceTime().Round(time.Microsecond), write.AvgServiceTime().Round(time.Microsecond))
+		if hist := HistogramCaption(blkdev.ServiceHist); hist != "" {
+			ret += "  " + hist + "\n"
+		}
+	}
+	files := model.BPF.BlockIOFileSummary(model.Proc.TargetInfo.FDPath, model.Proc.TargetInfo.FDFileID)
+	if len(files.ByDuration) > 0 {
+		ret += genericLabel.Render("Top files by device time") + "\n"
+		for i, file := range files.ByDuration {
+			if i == 2 {
+				break
+			}
+			ret += model.fileCaption(file)
+		}
+	}
+	if len(files.WritebackByDuration) > 0 {
+		ret += genericLabel.Render("Writeback on the target's behalf") + "\n"
+		for i, file := range files.WritebackByDuration {
+			if i == 2 {
+				break
+			}
+			ret += model.fileCaption(file)
 		}
-		sectors := fmt.Sprintf("%d sectors(%s)",
-			blkdev.SectorCount/model.BPF.SamplingIntervalSec,
-			(blkdev.IODuration / time.Duration(model.BPF.SamplingIntervalSec)).Round(1*time.Millisecond))
-		ret += fmt.Sprintf("%-26s %-18s /s", PathCaption(blkdev.DeviceName, 25), sectors)
 	}
 	return ret
 }
+
+func (model *BlkdevModel) sortedBlkdevs() []*BlockIOCounter {
+	blkdevs := model.BPF.BlockIOSummary(model.Proc.DiskStats).ByDuration
+	if model.Sort.Column() == BlkdevSortSectors {
+		sort.SliceStable(blkdevs, func(i, j int) bool {
+			return blkdevs[i].SectorCount > blkdevs[j].SectorCount
+		})
+	}
+	return slices.DeleteFunc(blkdevs, func(blkdev *BlockIOCounter) bool {
+		return !model.Filter.Match(blkdev.DeviceName, nil)
+	})
+}
+
+func (model *BlkdevModel) OpenDetail() bool {
+	return model.Cursor.OpenDetail()
+}
+
+// DetailView shows the target's operations on the selected block device by type, the device's own statistics since
+// boot, and the settings of its request queue.
+func (model *BlkdevModel) DetailView(width, height int) string {
+	name := model.Cursor.Detail
+	interval := model.BPF.SamplingIntervalSec
+	ret := genericLabel.Render("Block device "+name) + "\n"
+	blkdev, exists := model.BPF.BlockIOSummary(model.Proc.DiskStats).ByName[name]
+	if !exists {
+		blkdev = &BlockIOCounter{}
+		ret += "No IO in the latest interval.\n"
+	}
+	ret += fmt.Sprintf("%-8s %-10s %-14s %-14s %s\n", "", "ops/s", "sectors/s", "avg queue", "avg device")
+	for _, op := range []string{BlockIORead, BlockIOWrite, BlockIODiscard, BlockIOOther} {
+		counter := blkdev.Op(op)
+		ret += fmt.Sprintf("%-8s %-10d %-14d %-14v %v\n", op, counter.Ops/interval, counter.SectorCount/interval,
+			counter.AvgQueueTime().Round(time.Microsecond), counter.AvgServiceTime().Round(time.Microsecond))
+	}
+	if hist := HistogramCaption(blkdev.ServiceHist); hist != "" {
+		ret += "Device time " + hist + "\n"
+	}
+	for _, disk := range model.Proc.DiskStats {
+		if disk.DeviceName != name {
+			continue
+		}
+		ret += "\n" + genericLabel.Render("Since boot, all processes") + "\n"
+		ret += fmt.Sprintf("In flight %d, busy %v, weighted busy %v\n", disk.IOsInProgress,
+			time.Duration(disk.IOsTotalTicks)*time.Millisecond, time.Duration(disk.WeightedIOTicks)*time.Millisecond)
+		ret += fmt.Sprintf("Read  %d ops, %d merged, %d sectors, %v\n", disk.ReadIOs, disk.ReadMerges, disk.ReadSectors, time.Duration(disk.ReadTicks)*time.Millisecond)
+		ret += fmt.Sprintf("Write %d ops, %d merged, %d sectors, %v\n", disk.WriteIOs, disk.WriteMerges, disk.WriteSectors, time.Duration(disk.WriteTicks)*time.Millisecond)
+	}
+	// Partitions do not have a queue of their own.
+	if blockdev, err := blockdevice.NewDefaultFS(); err == nil {
+		if queue, err := blockdev.SysBlockDeviceQueueStats(name); err == nil {
+			ret += "\n" + genericLabel.Render("Request queue") + "\n"
+			ret += fmt.Sprintf("Scheduler %s, requests %d, rotational %d, write cache %s\n", queue.SchedulerCurrent, queue.NRRequests, queue.Rotational, queue.WriteCache)
+			ret += fmt.Sprintf("Max sectors %dKB, read ahead %dKB, logical block %dB, physical block %dB\n",
+				queue.MaxSectorsKB, queue.ReadAHeadKB, queue.LogicalBlockSize, queue.PhysicalBlockSize)
+		}
+	}
+	ret += "\n"
+	chartHeight := height - strings.Count(ret, "\n") - 1
+	return ret + model.History.RenderChart([]ChartSeries{
+		{Title: "Bytes read and written", Key: HistoryKey(HistoryBlkdev, name), Caption: RateCaption},
+	}, width, chartHeight)
+}
+
+func (model *BlkdevModel) fileCaption(file *BlockIOFileCounter) string {
+	return fmt.Sprintf("%-27s %-14s %v/s\n",
+		PathCaption(file.Name, 25),
+		fmt.Sprintf("%d sectors/s", file.SectorCount/model.BPF.SamplingIntervalSec),
+		(file.IODuration / time.Duration(model.BPF.SamplingIntervalSec)).Round(time.Microsecond))
+}
+
+func (model *BlkdevModel) ChartSeries() []ChartSeries {
+	return []ChartSeries{
+		{Title: "Block device bytes read", Key: HistoryKey(HistoryTotal, TotalBlkdevRead), Caption: RateCaption},
+		{Title: "Block device bytes written", Key: HistoryKey(HistoryTotal, TotalBlkdevWritten), Caption: RateCaption},
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs/blockdevice"
+)
+
+const (
+	BlockIORead    = "read"
+	BlockIOWrite   = "write"
+	BlockIODiscard = "discard"
+	BlockIOOther   = "other"
+)
+
+func (bpf *BpfTracer) blockIOIntervalStatements() string {
+	/*
+		The inodes dirtied by the target are remembered in two generations of maps, the older one is cleared once the
+		dirty pages of its inodes are due to have been written back. The inodes that are never written back, such as
+		those of deleted temporary files, do not pile up.
+	*/
+	return fmt.Sprintf(`
+    print(@blk_sectors); print(@blk_ops); print(@blk_queue_nanos); print(@blk_service_nanos); print(@blk_service_hist);
+    clear(@blk_sectors); clear(@blk_ops); clear(@blk_queue_nanos); clear(@blk_service_nanos); clear(@blk_service_hist);
+    print(@blk_file_nanos); print(@blk_file_sectors); print(@blk_wb_file_nanos); print(@blk_wb_file_sectors);
+    clear(@blk_file_nanos); clear(@blk_file_sectors); clear(@blk_wb_file_nanos); clear(@blk_wb_file_sectors);
+    print(@dirtied_pages); print(@dirty_throttle_millis);
+    clear(@dirtied_pages); clear(@dirty_throttle_millis);
+    @dirtied_ino_age++;
+    if (@dirtied_ino_age >= %d) {
+        @dirtied_ino_age = 0;
+        @dirtied_ino_gen = 1 - @dirtied_ino_gen;
+        if (@dirtied_ino_gen) {clear(@target_dirtied_ino_1);} else {clear(@target_dirtied_ino_0);}
+    }`, bpf.dirtyWritebackIntervals())
+}
+
+// dirtyWritebackIntervals is the number of sampling intervals it takes the kernel to write back a dirty page.
+func (bpf *BpfTracer) dirtyWritebackIntervals() int {
+	readCentisecs := func(name string, defaultValue int) int {
+		content, err := os.ReadFile("/proc/sys/vm/" + name)
+		if err != nil {
+			return defaultValue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		if err != nil {
+			return defaultValue
+		}
+		return value
+	}
+	centisecs := readCentisecs("dirty_expire_centisecs", 3000) + readCentisecs("dirty_writeback_centisecs", 500)
+	return max(1, (centisecs+bpf.SamplingIntervalSec*100-1)/(bpf.SamplingIntervalSec*100))
+}
+
+func (bpf *BpfTracer) blockIOProbes() string {
+	/*
+		Requests are identified by both device and sector, as sectors of different devices may coincide.
+		The target is identified when the request starts, the request is then followed through insertion into the
+		scheduler queue, issue to the device driver, and completion, which often take place in other contexts.
+		Queue time is measured from insertion (or start, if the request bypassed the scheduler) to issue, and service
+		time is measured from issue to completion.
+		A request merged into another is never issued on its own, so it is forgotten. A bio merged into the front of a
+		request becomes the start of the request, which keeps the time of the request, and a bio merged into its back
+		changes nothing. A requeued request is queued again from the time of the requeue, and a request that completes
+		without having been issued, such as one failed by the driver, is forgotten.
+	*/
+	return fmt.Sprintf(`
+tracepoint:block:block_io_start /pid == %d/ {
+    @blk_sectors[args->dev, args->rwbs] += args->nr_sector;
+    @blk_queued[args->dev, args->sector] = nsecs;
+}
+tracepoint:block:block_rq_insert /@blk_queued[args->dev, args->sector]/ {
+    @blk_queued[args->dev, args->sector] = nsecs;
+}
+tracepoint:block:block_bio_frontmerge /@blk_queued[args->dev, args->sector + args->nr_sector]/ {
+    @blk_queued[args->dev, args->sector] = @blk_queued[args->dev, args->sector + args->nr_sector];
+    delete(@blk_queued[args->dev, args->sector + args->nr_sector]);
+}
+tracepoint:block:block_rq_merge /@blk_queued[args->dev, args->sector]/ {
+    delete(@blk_queued[args->dev, args->sector]);
+}
+tracepoint:block:block_rq_requeue {
+    if (@blk_issued[args->dev, args->sector]) {
+        @blk_queued[args->dev, args->sector] = nsecs;
+    }
+    delete(@blk_issued[args->dev, args->sector]);
+    delete(@blk_wb_issued[args->dev, args->sector]);
+    delete(@blk_rq_ino[args->dev, args->sector]);
+    delete(@blk_rq_fs_dev[args->dev, args->sector]);
+}
+tracepoint:block:block_rq_issue /@blk_queued[args->dev, args->sector]/ {
+    @blk_queue_nanos[args->dev, args->rwbs] += nsecs - @blk_queued[args->dev, args->sector];
+    @blk_issued[args->dev, args->sector] = nsecs;
+    delete(@blk_queued[args->dev, args->sector]);
+}
+tracepoint:block:block_rq_complete /@blk_issued[args->dev, args->sector]/ {
+    $service = nsecs - @blk_issued[args->dev, args->sector];
+    @blk_ops[args->dev, args->rwbs] = count();
+    @blk_service_nanos[args->dev, args->rwbs] += $service;
+    @blk_service_hist[args->dev] = hist($service / 1000);
+    if (@blk_rq_ino[args->dev, args->sector]) {
+        @blk_file_nanos[@blk_rq_fs_dev[args->dev, args->sector], @blk_rq_ino[args->dev, args->sector]] += $service;
+        @blk_file_sectors[@blk_rq_fs_dev[args->dev, args->sector], @blk_rq_ino[args->dev, args->sector]] += args->nr_sector;
+    }
+    delete(@blk_issued[args->dev, args->sector]);
+    delete(@blk_rq_ino[args->dev, args->sector]);
+    delete(@blk_rq_fs_dev[args->dev, args->sector]);
+}
+tracepoint:block:block_rq_complete /@blk_wb_issued[args->dev, args->sector]/ {
+    $service = nsecs - @blk_wb_issued[args->dev, args->sector];
+    @blk_wb_file_nanos[@blk_rq_fs_dev[args->dev, args->sector], @blk_rq_ino[args->dev, args->sector]] += $service;
+    @blk_wb_file_sectors[@blk_rq_fs_dev[args->dev, args->sector], @blk_rq_ino[args->dev, args->sector]] += args->nr_sector;
+    delete(@blk_wb_issued[args->dev, args->sector]);
+    delete(@blk_rq_ino[args->dev, args->sector]);
+    delete(@blk_rq_fs_dev[args->dev, args->sector]);
+}
+tracepoint:block:block_rq_complete /@blk_queued[args->dev, args->sector]/ {
+    delete(@blk_queued[args->dev, args->sector]);
+}
+`, bpf.PID) + bpf.blockIOFileProbes()
+}
+
+func (bpf *BpfTracer) blockIOFileProbes() string {
+	/*
+		The file behind a request is found from the owner of the page cache page of its first bio, when the request
+		is issued to the device driver. Direct IO and swap pages are anonymous and do not lead to a file.
+		Requests that were not started by the target are attributed to it as writeback, if they write out a file
+		whose pages the target has dirtied. The files are identified by both the device of their file system and inode,
+		as inode numbers of different file systems may coincide. The writeback_dirty_folio raw tracepoint gives the
+		address space of the page, its tracepoint fields do not include the device.
+	*/
+	return fmt.Sprintf(`
+rawtracepoint:writeback_dirty_folio /pid == %d/ {
+    if (arg1 != 0) {
+        $inode = ((struct address_space *)arg1)->host;
+        if (@dirtied_ino_gen) {
+            @target_dirtied_ino_1[$inode->i_sb->s_dev, $inode->i_ino] = 1;
+        } else {
+            @target_dirtied_ino_0[$inode->i_sb->s_dev, $inode->i_ino] = 1;
+        }
+    }
+    @dirtied_pages[pid] = count();
+}
+tracepoint:writeback:balance_dirty_pages /pid == %d/ {
+    @dirty_throttle_millis[pid] = sum(args->pause);
+}
+kprobe:blk_mq_start_request {
+    $rq = (struct request *)arg0;
+    $dev = ($rq->q->disk->major << 20) | $rq->q->disk->first_minor;
+    $sector = $rq->__sector;
+    $mapping = (uint64)$rq->bio->bi_io_vec->bv_page->mapping;
+    if ($mapping != 0 && ($mapping & 1) == 0) {
+        $inode = ((struct address_space *)$mapping)->host;
+        if (@blk_queued[$dev, $sector]) {
+            @blk_rq_ino[$dev, $sector] = $inode->i_ino;
+            @blk_rq_fs_dev[$dev, $sector] = $inode->i_sb->s_dev;
+        } else if (@target_dirtied_ino_0[$inode->i_sb->s_dev, $inode->i_ino] || @target_dirtied_ino_1[$inode->i_sb->s_dev, $inode->i_ino]) {
+            @blk_rq_ino[$dev, $sector] = $inode->i_ino;
+            @blk_rq_fs_dev[$dev, $sector] = $inode->i_sb->s_dev;
+            @blk_wb_issued[$dev, $sector] = nsecs;
+        }
+    }
+}
+`, bpf.PID, bpf.PID)
+}
+
+// BlockIOOp classifies a request by its RWBS flags, such as "R", "WS", "FWFS", or "DS".
+func BlockIOOp(rwbs string) string {
+	rwbs = strings.TrimLeft(rwbs, "F")
+	switch {
+	case strings.HasPrefix(rwbs, "W"):
+		return BlockIOWrite
+	case strings.HasPrefix(rwbs, "R"):
+		return BlockIORead
+	case strings.HasPrefix(rwbs, "D"):
+		return BlockIODiscard
+	default:
+		return BlockIOOther
+	}
+}
+
+// parseDevRwbsKey parses a map key of kernel dev_t and RWBS flags into "major:minor" and the operation.
+func parseDevRwbsKey(key string) (string, string, bool) {
+	devStr, rwbs, found := strings.Cut(key, ",")
+	if !found {
+		return "", "", false
+	}
+	devt, err := strconv.Atoi(devStr)
+	if err != nil {
+		return "", "", false
+	}
+	major, minor := decodeKernelDevt(devt)
+	return fmt.Sprintf("%d:%d", major, minor), BlockIOOp(strings.Trim(rwbs, `" `)), true
+}
+
+type BlockIOOpCounter struct {
+	Ops         int
+	SectorCount int
+	QueueTime   time.Duration
+	ServiceTime time.Duration
+}
+
+func (counter BlockIOOpCounter) AvgServiceTime() time.Duration {
+	if counter.Ops == 0 {
+		return 0
+	}
+	return counter.ServiceTime / time.Duration(counter.Ops)
+}
+
+func (counter BlockIOOpCounter) AvgQueueTime() time.Duration {
+	if counter.Ops == 0 {
+		return 0
+	}
+	return counter.QueueTime / time.Duration(counter.Ops)
+}
+
+type BlockIOCounter struct {
+	DeviceName string
+	MajorMinor string
+	// SectorCount, QueueTime, and IODuration are the sum of all operations, IODuration is the device service time.
+	SectorCount int
+	QueueTime   time.Duration
+	IODuration  time.Duration
+	ByOp        map[string]*BlockIOOpCounter
+	// ServiceHist is the histogram of device service time in microseconds.
+	ServiceHist []BpfHistBucket
+}
+
+func (counter *BlockIOCounter) Op(op string) BlockIOOpCounter {
+	if opCounter, exists := counter.ByOp[op]; exists {
+		return *opCounter
+	}
+	return BlockIOOpCounter{}
+}
+
+type BlockIOSummary struct {
+	ByName     map[string]*BlockIOCounter
+	ByDuration []*BlockIOCounter
+}
+
+func (bpf *BpfTracer) BlockIOSummary(diskStats map[string]blockdevice.Diskstats) *BlockIOSummary {
+	ret := &BlockIOSummary{
+		ByName:     make(map[string]*BlockIOCounter),
+		ByDuration: []*BlockIOCounter{},
+	}
+	getCounter := func(majorMinor string) *BlockIOCounter {
+		disk, exists := diskStats[majorMinor]
+		if !exists {
+			return nil
+		}
+		if _, exists := ret.ByName[disk.DeviceName]; !exists {
+			ret.ByName[disk.DeviceName] = &BlockIOCounter{
+				DeviceName: disk.DeviceName,
+				MajorMinor: majorMinor,
+				ByOp:       make(map[string]*BlockIOOpCounter),
+			}
+		}
+		return ret.ByName[disk.DeviceName]
+	}
+	getOpCounter := func(key string) (*BlockIOCounter, *BlockIOOpCounter) {
+		majorMinor, op, ok := parseDevRwbsKey(key)
+		if !ok {
+			return nil, nil
+		}
+		counter := getCounter(majorMinor)
+		if counter == nil {
+			return nil, nil
+		}
+		if _, exists := counter.ByOp[op]; !exists {
+			counter.ByOp[op] = &BlockIOOpCounter{}
+		}
+		return counter, counter.ByOp[op]
+	}
+	for key, sectors := range bpf.BlockIOSectors {
+		if counter, opCounter := getOpCounter(key); counter != nil {
+			counter.SectorCount += sectors
+			opCounter.SectorCount += sectors
+		}
+	}
+	for key, ops := range bpf.BlockIOOps {
+		if _, opCounter := getOpCounter(key); opCounter != nil {
+			opCounter.Ops += ops
+		}
+	}
+	for key, nanos := range bpf.BlockIOQueueNanos {
+		if counter, opCounter := getOpCounter(key); counter != nil {
+			counter.QueueTime += time.Duration(nanos)
+			opCounter.QueueTime += time.Duration(nanos)
+		}
+	}
+	for key, nanos := range bpf.BlockIOServiceNanos {
+		if counter, opCounter := getOpCounter(key); counter != nil {
+			counter.IODuration += time.Duration(nanos)
+			opCounter.ServiceTime += time.Duration(nanos)
+		}
+	}
+	for devStr, hist := range bpf.BlockIOServiceHist {
+		devt, _ := strconv.Atoi(devStr)
+		major, minor := decodeKernelDevt(devt)
+		if counter := getCounter(fmt.Sprintf("%d:%d", major, minor)); counter != nil {
+			counter.ServiceHist = hist
+		}
+	}
+	for _, ioCounter := range ret.ByName {
+		ret.ByDuration = append(ret.ByDuration, ioCounter)
+	}
+	sort.Slice(ret.ByDuration, func(i, j int) bool {
+		a := ret.ByDuration[i]
+		b := ret.ByDuration[j]
+		return a.IODuration+a.QueueTime > b.IODuration+b.QueueTime
+	})
+	return ret
+}
+
+func (bpf *BpfTracer) observeBlockIOServiceHist(hist map[string][]BpfHistBucket) {
+	if bpf.Metrics == nil {
+		return
+	}
+	diskStats := ReadDiskStats()
+	for devStr, buckets := range hist {
+		devt, _ := strconv.Atoi(devStr)
+		major, minor := decodeKernelDevt(devt)
+		device := fmt.Sprintf("%d:%d", major, minor)
+		if disk, exists := diskStats[device]; exists {
+			device = disk.DeviceName
+		}
+		labels := prometheus.Labels{DeviceLabel: device}
+		for _, bucket := range buckets {
+			if bucket.Min == nil || bucket.Max == nil {
+				continue
+			}
+			// The exact sum is not known from a histogram, the middle of the bucket is a close enough estimate.
+			sum := float64(*bucket.Min+*bucket.Max) / 2 * float64(bucket.Count) / 1e6
+			bpf.Metrics.BlockIOServiceSeconds.Observe(labels, float64(*bucket.Max)/1e6, uint64(bucket.Count), sum)
+		}
+	}
+}
+
+type BlockIOFileCounter struct {
+	FileID      FileID
+	Name        string
+	SectorCount int
+	IODuration  time.Duration
+}
+
+type BlockIOFileSummary struct {
+	// ByDuration are the files of requests started by the target.
+	ByDuration []*BlockIOFileCounter
+	// WritebackByDuration are the files dirtied by the target and flushed by kernel threads on its behalf.
+	WritebackByDuration []*BlockIOFileCounter
+}
+
+func blockIOFileCounters(nanosByFile, sectorsByFile map[string]int, fdPaths map[int]string, fdFileIDs map[int]FileID) []*BlockIOFileCounter {
+	/*
+		The kernel identifies a file by the device of its superblock, which is not the device stat reports on overlayfs
+		and btrfs subvolumes. The files are therefore matched by inode number, and the device only tells apart the open
+		files of the same inode number on different file systems.
+	*/
+	pathByFileID := make(map[FileID]string)
+	pathsByInode := make(map[uint64]map[string]bool)
+	for fd, fileID := range fdFileIDs {
+		if path, exists := fdPaths[fd]; exists {
+			pathByFileID[fileID] = path
+			if pathsByInode[fileID.Inode] == nil {
+				pathsByInode[fileID.Inode] = make(map[string]bool)
+			}
+			pathsByInode[fileID.Inode][path] = true
+		}
+	}
+	lookupPath := func(fileID FileID) (string, bool) {
+		if path, exists := pathByFileID[fileID]; exists {
+			return path, true
+		}
+		if paths := pathsByInode[fileID.Inode]; len(paths) == 1 {
+			for path := range paths {
+				return path, true
+			}
+		}
+		return "", false
+	}
+	/*
+		Sample data, the key consists of the kernel dev_t of the file system and inode number:
+		{"type": "map", "data": {"@blk_file_nanos": {"8388609,1835011": 5888654}}}
+	*/
+	byFileID := make(map[FileID]*BlockIOFileCounter)
+	getCounter := func(key string) *BlockIOFileCounter {
+		devStr, inoStr, found := strings.Cut(key, ",")
+		if !found {
+			return nil
+		}
+		devt, _ := strconv.Atoi(devStr)
+		ino, _ := strconv.ParseUint(inoStr, 10, 64)
+		major, minor := decodeKernelDevt(devt)
+		fileID := FileID{Major: major, Minor: minor, Inode: ino}
+		if _, exists := byFileID[fileID]; !exists {
+			name, exists := lookupPath(fileID)
+			if !exists {
+				name = fmt.Sprintf("inode %d on %s", ino, fileID.MajorMinor())
+			}
+			byFileID[fileID] = &BlockIOFileCounter{FileID: fileID, Name: name}
+		}
+		return byFileID[fileID]
+	}
+	for key, nanos := range nanosByFile {
+		if counter := getCounter(key); counter != nil {
+			counter.IODuration = time.Duration(nanos)
+		}
+	}
+	for key, sectors := range sectorsByFile {
+		if counter := getCounter(key); counter != nil {
+			counter.SectorCount = sectors
+		}
+	}
+	ret := make([]*BlockIOFileCounter, 0, len(byFileID))
+	for _, counter := range byFileID {
+		ret = append(ret, counter)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].IODuration > ret[j].IODuration
+	})
+	return ret
+}
+
+func (bpf *BpfTracer) BlockIOFileSummary(fdPaths map[int]string, fdFileIDs map[int]FileID) *BlockIOFileSummary {
+	return &BlockIOFileSummary{
+		ByDuration:          blockIOFileCounters(bpf.BlockIOFileNanos, bpf.BlockIOFileSectors, fdPaths, fdFileIDs),
+		WritebackByDuration: blockIOFileCounters(bpf.WritebackFileNanos, bpf.WritebackFileSectors, fdPaths, fdFileIDs),
+	}
+}
+
+type WritebackSummary struct {
+	// DirtiedBytes is an estimate as the tracepoint does not tell the size of large folios.
+	DirtiedBytes     int
+	WrittenBackBytes int
+	ThrottleTime     time.Duration
+}
+
+func (bpf *BpfTracer) WritebackSummary() *WritebackSummary {
+	ret := &WritebackSummary{}
+	for _, pages := range bpf.DirtiedPages {
+		ret.DirtiedBytes += pages * os.Getpagesize()
+	}
+	for _, sectors := range bpf.WritebackFileSectors {
+		ret.WrittenBackBytes += sectors * 512
+	}
+	for _, millis := range bpf.DirtyThrottleMillis {
+		ret.ThrottleTime += time.Duration(millis) * time.Millisecond
+	}
+	return ret
+}
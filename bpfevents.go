@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	MaxSyscallEvents = 500
+	SyscallEventTag  = "procshave_syscall_event"
+)
+
+type SyscallArgKind int
+
+const (
+	ArgsRaw SyscallArgKind = iota
+	ArgsFD
+	ArgsPath
+	ArgsDirFDPath
+	ArgsFDSockaddr
+)
+
+var SyscallEventArgKinds = map[string]SyscallArgKind{
+	"read": ArgsFD, "write": ArgsFD, "pread64": ArgsFD, "pwrite64": ArgsFD, "readv": ArgsFD, "writev": ArgsFD,
+	"close": ArgsFD, "fsync": ArgsFD, "fdatasync": ArgsFD, "ftruncate": ArgsFD, "lseek": ArgsFD, "fstat": ArgsFD,
+	"getdents64": ArgsFD, "ioctl": ArgsFD, "fcntl": ArgsFD, "flock": ArgsFD, "sendto": ArgsFD, "recvfrom": ArgsFD,
+	"sendmsg": ArgsFD, "recvmsg": ArgsFD, "accept": ArgsFD, "accept4": ArgsFD, "listen": ArgsFD, "shutdown": ArgsFD,
+	"open": ArgsPath, "creat": ArgsPath, "stat": ArgsPath, "lstat": ArgsPath, "access": ArgsPath, "unlink": ArgsPath,
+	"mkdir": ArgsPath, "rmdir": ArgsPath, "chdir": ArgsPath, "truncate": ArgsPath, "readlink": ArgsPath, "execve": ArgsPath,
+	"openat": ArgsDirFDPath, "openat2": ArgsDirFDPath, "newfstatat": ArgsDirFDPath, "statx": ArgsDirFDPath,
+	"unlinkat": ArgsDirFDPath, "mkdirat": ArgsDirFDPath, "faccessat": ArgsDirFDPath, "faccessat2": ArgsDirFDPath,
+	"readlinkat": ArgsDirFDPath, "renameat": ArgsDirFDPath, "renameat2": ArgsDirFDPath, "execveat": ArgsDirFDPath,
+	"connect": ArgsFDSockaddr, "bind": ArgsFDSockaddr,
+}
+
+// ParseEventSyscalls turns a comma separated list of syscall names into syscall numbers of the running architecture.
+func ParseEventSyscalls(names string) ([]int, error) {
+	numbers := make(map[string]int)
+	for number, name := range SyscallNames {
+		numbers[name] = number
+	}
+	var ret []int
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		number, exists := numbers[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown syscall %q", name)
+		}
+		ret = append(ret, number)
+	}
+	return ret, nil
+}
+
+func (bpf *BpfTracer) syscallEventProbes() string {
+	var ret string
+	retFilter := "1"
+	if bpf.EventErrorsOnly {
+		retFilter = "args->ret < 0"
+	}
+	for _, number := range bpf.EventSyscalls {
+		var capture, cleanup, extra, extraFmt string
+		switch SyscallEventArgKinds[SyscallName(number)] {
+		case ArgsPath:
+			capture, cleanup = "@evt_path[tid] = str(uptr(args->args[0]));", "delete(@evt_path[tid]);"
+			extra, extraFmt = "@evt_path[tid]", "%s"
+		case ArgsDirFDPath:
+			capture, cleanup = "@evt_path[tid] = str(uptr(args->args[1]));", "delete(@evt_path[tid]);"
+			extra, extraFmt = "@evt_path[tid]", "%s"
+		case ArgsFDSockaddr:
+			capture, cleanup = "@evt_sockaddr[tid] = buf(uptr(args->args[1]), 28);", "delete(@evt_sockaddr[tid]);"
+			extra, extraFmt = "@evt_sockaddr[tid]", "%r"
+		default:
+			extra, extraFmt = `""`, "%s"
+		}
+		ret += fmt.Sprintf(`
+tracepoint:raw_syscalls:sys_enter /pid == %d && args->id == %d/ {
+    @evt_ts[tid] = nsecs;
+    @evt_arg0[tid] = args->args[0]; @evt_arg1[tid] = args->args[1]; @evt_arg2[tid] = args->args[2];
+    %s
+}
+tracepoint:raw_syscalls:sys_exit /pid == %d && args->id == %d && @evt_ts[tid]/ {
+    if (%s) {
+        printf("%s %%d %%d %%d %%d %%d %%d %%d %%d %s\n", @evt_ts[tid], nsecs - @evt_ts[tid], tid, args->id, @evt_arg0[tid], @evt_arg1[tid], @evt_arg2[tid], args->ret, %s);
+    }
+    delete(@evt_ts[tid]); delete(@evt_arg0[tid]); delete(@evt_arg1[tid]); delete(@evt_arg2[tid]);
+    %s
+}
+`, bpf.PID, number, capture, bpf.PID, number, retFilter, SyscallEventTag, extraFmt, extra, cleanup)
+	}
+	return ret
+}
+
+type SyscallEvent struct {
+	Time     time.Time
+	Duration time.Duration
+	TID      int
+	Number   int
+	Name     string
+	Args     [3]int64
+	Ret      int64
+	Path     string
+	Address  string
+}
+
+func parseBpfInt(str string) int64 {
+	if val, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return val
+	}
+	val, _ := strconv.ParseUint(str, 10, 64)
+	return int64(val)
+}
+
+// parseBpfBuffer decodes a buffer printed by bpftrace "%r", which escapes non-printable bytes as \xNN.
+func parseBpfBuffer(str string) []byte {
+	var ret []byte
+	for i := 0; i < len(str); i++ {
+		if str[i] == '\\' && i+3 < len(str) && str[i+1] == 'x' {
+			if val, err := strconv.ParseUint(str[i+2:i+4], 16, 8); err == nil {
+				ret = append(ret, byte(val))
+				i += 3
+				continue
+			}
+		}
+		ret = append(ret, str[i])
+	}
+	return ret
+}
+
+func SockaddrCaption(sockaddr []byte) string {
+	if len(sockaddr) < 2 {
+		return ""
+	}
+	switch binary.NativeEndian.Uint16(sockaddr[0:2]) {
+	case unix.AF_INET:
+		if len(sockaddr) < 8 {
+			return ""
+		}
+		return net.JoinHostPort(net.IP(sockaddr[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(sockaddr[2:4]))))
+	case unix.AF_INET6:
+		if len(sockaddr) < 24 {
+			return ""
+		}
+		return net.JoinHostPort(net.IP(sockaddr[8:24]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(sockaddr[2:4]))))
+	case unix.AF_UNIX:
+		return "unix:" + strings.TrimRight(string(sockaddr[2:]), "\x00")
+	}
+	return ""
+}
+
+func parseSyscallEvent(data string) (SyscallEvent, bool) {
+	/*
+		Sample data:
+		procshave_syscall_event 1234567890 25000 4321 257 18446744073709551516 140737488346096 524288 3 /etc/hosts
+	*/
+	fields := strings.SplitN(strings.TrimRight(data, "\n"), " ", 10)
+	if len(fields) != 10 || fields[0] != SyscallEventTag {
+		return SyscallEvent{}, false
+	}
+	evt := SyscallEvent{
+		Time:     bpfTimestamp(parseBpfInt(fields[1])),
+		Duration: time.Duration(parseBpfInt(fields[2])),
+		TID:      int(parseBpfInt(fields[3])),
+		Number:   int(parseBpfInt(fields[4])),
+		Args:     [3]int64{parseBpfInt(fields[5]), parseBpfInt(fields[6]), parseBpfInt(fields[7])},
+		Ret:      parseBpfInt(fields[8]),
+	}
+	evt.Name = SyscallName(evt.Number)
+	switch SyscallEventArgKinds[evt.Name] {
+	case ArgsPath, ArgsDirFDPath:
+		evt.Path = fields[9]
+	case ArgsFDSockaddr:
+		evt.Address = SockaddrCaption(parseBpfBuffer(fields[9]))
+	}
+	return evt, true
+}
+
+func fdCaption(fd int64, fdPaths map[int]string) string {
+	if fd == unix.AT_FDCWD {
+		return "AT_FDCWD"
+	}
+	if path, exists := fdPaths[int(fd)]; exists {
+		return fmt.Sprintf("%d<%s>", fd, path)
+	}
+	return strconv.FormatInt(fd, 10)
+}
+
+// Format renders the event in a strace -T like manner, file descriptors are resolved to paths using fdPaths.
+func (evt SyscallEvent) Format(fdPaths map[int]string) string {
+	var args string
+	switch SyscallEventArgKinds[evt.Name] {
+	case ArgsFD:
+		args = fmt.Sprintf("%s, %#x, %d", fdCaption(evt.Args[0], fdPaths), evt.Args[1], evt.Args[2])
+	case ArgsPath:
+		args = fmt.Sprintf("%q, %#x, %#x", evt.Path, evt.Args[1], evt.Args[2])
+	case ArgsDirFDPath:
+		args = fmt.Sprintf("%s, %q, %#x", fdCaption(evt.Args[0], fdPaths), evt.Path, evt.Args[2])
+	case ArgsFDSockaddr:
+		args = fmt.Sprintf("%s, %s, %d", fdCaption(evt.Args[0], fdPaths), evt.Address, evt.Args[2])
+	default:
+		args = fmt.Sprintf("%#x, %#x, %#x", evt.Args[0], evt.Args[1], evt.Args[2])
+	}
+	ret := strconv.FormatInt(evt.Ret, 10)
+	if evt.Ret < 0 {
+		ret = "-1 " + ErrnoName(int(-evt.Ret))
+	}
+	return fmt.Sprintf("%s %-7d %s(%s) = %s <%.6f>", evt.Time.Format("15:04:05.000000"), evt.TID, evt.Name, args, ret, evt.Duration.Seconds())
+}
+
+func (bpf *BpfTracer) handleSyscallEvent(data string) {
+	evt, ok := parseSyscallEvent(data)
+	if !ok {
+		return
+	}
+	bpf.mutex.Lock()
+	bpf.SyscallEvents = append(bpf.SyscallEvents, evt)
+	if len(bpf.SyscallEvents) > MaxSyscallEvents {
+		bpf.SyscallEvents = bpf.SyscallEvents[len(bpf.SyscallEvents)-MaxSyscallEvents:]
+	}
+	onEvent := bpf.OnSyscallEvent
+	bpf.mutex.Unlock()
+	if onEvent != nil {
+		onEvent(evt)
+	}
+}
+
+func (bpf *BpfTracer) LatestSyscallEvents(count int) []SyscallEvent {
+	bpf.mutex.Lock()
+	defer bpf.mutex.Unlock()
+	ret := make([]SyscallEvent, 0, count)
+	ret = append(ret, bpf.SyscallEvents[max(0, len(bpf.SyscallEvents)-count):]...)
+	return ret
+}
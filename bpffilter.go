@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/prometheus/procfs"
+)
+
+// ParseNetFilter parses comma separated IP addresses and CIDR networks, e.g. "10.0.0.0/8,192.168.1.10,fd00::/8".
+func ParseNetFilter(spec string) ([]*net.IPNet, error) {
+	var ret []*net.IPNet
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		network, err := ParseNetwork(item)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, network)
+	}
+	return ret, nil
+}
+
+// ParseNetwork parses a CIDR network or an IP address, which is a network of a single address.
+func ParseNetwork(item string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(item); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(item)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is neither an IP address nor a CIDR network", item)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ParsePathFilter parses comma separated absolute path prefixes, e.g. "/var/log/,/data/".
+func ParsePathFilter(spec string) ([]string, error) {
+	var ret []string
+	for _, prefix := range strings.Split(spec, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "" {
+			continue
+		}
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("path prefix %q is not absolute", prefix)
+		}
+		// The prefixes become string literals of the bpftrace program.
+		if strings.ContainsAny(prefix, `"\`) {
+			return nil, fmt.Errorf("path prefix %q must not contain quotes or backslashes", prefix)
+		}
+		ret = append(ret, prefix)
+	}
+	return ret, nil
+}
+
+// fdFilterPredicate narrows the file IO probes down to the FDs of the paths in FilterPaths.
+func (bpf *BpfTracer) fdFilterPredicate() string {
+	if len(bpf.FilterPaths) == 0 {
+		return ""
+	}
+	return " && @fd_match[(int64)args->fd]"
+}
+
+func (bpf *BpfTracer) pathFilterProbes() string {
+	if len(bpf.FilterPaths) == 0 {
+		return ""
+	}
+	/*
+		The FD keys are cast to the same integer type, as bpftrace requires the keys of a map to be of one type.
+		The FDs already open are matched by their paths in /proc when the program starts, the FDs opened later are
+		matched by the path given to openat. Relative paths given to openat are not matched.
+	*/
+	var initial string
+	if proc, err := procfs.NewProc(bpf.PID); err == nil {
+		fdTargets, _ := proc.FileDescriptorTargets()
+		fdNumbers, _ := proc.FileDescriptors()
+		if len(fdTargets) == len(fdNumbers) {
+			for i, fd := range fdNumbers {
+				if bpf.matchFilterPath(fdTargets[i]) {
+					initial += fmt.Sprintf("    @fd_match[(int64)%d] = 1;\n", fd)
+				}
+			}
+		}
+	}
+	var conditions []string
+	for _, prefix := range bpf.FilterPaths {
+		conditions = append(conditions, fmt.Sprintf(`strncmp($path, "%s", %d) == 0`, prefix, len(prefix)))
+	}
+	return fmt.Sprintf(`
+BEGIN {
+%s}
+tracepoint:syscalls:sys_enter_openat /pid == %d/ {
+    $path = str(args->filename);
+    if (%s) {@open_match[tid] = 1;}
+}
+tracepoint:syscalls:sys_exit_openat /pid == %d && @open_match[tid]/ {
+    if (args->ret >= 0) {@fd_match[(int64)args->ret] = 1;}
+    delete(@open_match[tid]);
+}
+tracepoint:syscalls:sys_enter_close /pid == %d/ {
+    delete(@fd_match[(int64)args->fd]);
+}
+`, initial, bpf.PID, strings.Join(conditions, " || "), bpf.PID, bpf.PID)
+}
+
+func (bpf *BpfTracer) matchFilterPath(path string) bool {
+	for _, prefix := range bpf.FilterPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// tcpFilterPredicate narrows the TCP traffic probe down to the segments from or to the networks in FilterNets.
+func (bpf *BpfTracer) tcpFilterPredicate() string {
+	if len(bpf.FilterNets) == 0 {
+		return ""
+	}
+	var conditions []string
+	for _, network := range bpf.FilterNets {
+		conditions = append(conditions, sockaddrInNetwork("args->saddr", network), sockaddrInNetwork("args->daddr", network))
+	}
+	return " && (" + strings.Join(conditions, " || ") + ")"
+}
+
+// sockaddrInNetwork tells whether the sockaddr_in or sockaddr_in6 array field of a tracepoint is in the network, by
+// comparing the address byte by byte. The IPv4 networks also match the IPv4-mapped IPv6 addresses.
+func sockaddrInNetwork(field string, network *net.IPNet) string {
+	ones, _ := network.Mask.Size()
+	bytesEqual := func(offset int, addr []byte, prefixBits int) []string {
+		var ret []string
+		for i := 0; i < len(addr) && prefixBits > 0; i++ {
+			mask := 0xff
+			if prefixBits < 8 {
+				mask = 0xff << (8 - prefixBits) & 0xff
+			}
+			// The bytes may be signed in bpftrace, the mask takes care of the sign extension.
+			ret = append(ret, fmt.Sprintf("(%s[%d] & %#x) == %d", field, offset+i, mask, int(addr[i])&mask))
+			prefixBits -= 8
+		}
+		return ret
+	}
+	if ip4 := network.IP.To4(); ip4 != nil {
+		v4 := append([]string{fmt.Sprintf("%s[0] == 2", field)}, bytesEqual(4, ip4, ones)...)
+		mapped := append([]string{fmt.Sprintf("%s[0] == 10", field)}, bytesEqual(8, net.IPv4(0, 0, 0, 0)[:12], 96)...)
+		mapped = append(mapped, bytesEqual(20, ip4, ones)...)
+		return "((" + strings.Join(v4, " && ") + ") || (" + strings.Join(mapped, " && ") + "))"
+	}
+	v6 := append([]string{fmt.Sprintf("%s[0] == 10", field)}, bytesEqual(8, network.IP.To16(), ones)...)
+	return "(" + strings.Join(v6, " && ") + ")"
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	HTTPSampleTag = "procshave_http_sample"
+	// HTTPSampleBytes is the default string and buffer length limit of bpftrace.
+	HTTPSampleBytes = 64
+	// HTTPOtherRoute replaces the routes beyond the cardinality limit.
+	HTTPOtherRoute = "other"
+	// MaxHTTPPendingRequests bounds the requests of a connection that are waiting for a response.
+	MaxHTTPPendingRequests = 100
+	h2Preface              = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+)
+
+var (
+	// HTTPPayloadPrefixes are the beginnings of HTTP/1 requests, HTTP/1 responses, and the HTTP/2 connection preface.
+	HTTPPayloadPrefixes = []string{"GET ", "POST", "PUT ", "DELE", "HEAD", "PATC", "OPTI", "HTTP", "PRI "}
+	HTTPMethods         = map[string]bool{"GET": true, "POST": true, "PUT": true, "DELETE": true, "HEAD": true, "PATCH": true, "OPTIONS": true}
+	// HTTPRouteIDRegex matches the path segments that are likely IDs: numbers, UUIDs, and long hex strings.
+	HTTPRouteIDRegex = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,})$`)
+	// HTTPLatencyBuckets are the upper bounds of the request latency histogram in seconds.
+	HTTPLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+func (bpf *BpfTracer) httpProbes() string {
+	if !bpf.HTTPEnabled {
+		return ""
+	}
+	/*
+		Only the payloads beginning like HTTP/1 or the HTTP/2 preface are sampled. The socket that sent or received the
+		preface is remembered so that its subsequent HTTP/2 frames are sampled too.
+	*/
+	var prefixes []string
+	for _, prefix := range HTTPPayloadPrefixes {
+		prefixes = append(prefixes, fmt.Sprintf(`strncmp($head, "%s", 4) == 0`, prefix))
+	}
+	sampleFilter := strings.Join(prefixes, " || ")
+	sample := func(direction, bufExpr, lenExpr string) string {
+		return fmt.Sprintf(`
+        $head = str(uptr(%s), 8);
+        if (%s || @http_h2_fd[$fd]) {
+            if (strncmp($head, "PRI ", 4) == 0) {@http_h2_fd[$fd] = 1;}
+            printf("%s\t%s\t%%d\t%%d\t%%d\t%%r\n", nsecs, $fd, %s, buf(uptr(%s), %d));
+        }`, bufExpr, sampleFilter, HTTPSampleTag, direction, lenExpr, bufExpr, HTTPSampleBytes)
+	}
+	return fmt.Sprintf(`
+tracepoint:syscalls:sys_enter_read /pid == %d/ {
+    @http_rbuf[tid] = (uint64)args->buf;
+    @http_rfd[tid] = args->fd;
+}
+tracepoint:syscalls:sys_enter_recvfrom /pid == %d/ {
+    @http_rbuf[tid] = (uint64)args->ubuf;
+    @http_rfd[tid] = args->fd;
+}
+tracepoint:syscalls:sys_exit_read /pid == %d && @http_rbuf[tid]/ {
+    if (args->ret > 0) {
+        $fd = @http_rfd[tid];%s
+    }
+    delete(@http_rbuf[tid]);
+    delete(@http_rfd[tid]);
+}
+tracepoint:syscalls:sys_exit_recvfrom /pid == %d && @http_rbuf[tid]/ {
+    if (args->ret > 0) {
+        $fd = @http_rfd[tid];%s
+    }
+    delete(@http_rbuf[tid]);
+    delete(@http_rfd[tid]);
+}
+tracepoint:syscalls:sys_enter_write /pid == %d && args->count > 0/ {
+    $fd = args->fd;%s
+}
+tracepoint:syscalls:sys_enter_sendto /pid == %d && args->len > 0/ {
+    $fd = args->fd;%s
+}
+tracepoint:syscalls:sys_enter_close /pid == %d/ {
+    delete(@http_h2_fd[args->fd]);
+}
+`, bpf.PID, bpf.PID,
+		bpf.PID, sample("read", "@http_rbuf[tid]", "args->ret"),
+		bpf.PID, sample("read", "@http_rbuf[tid]", "args->ret"),
+		bpf.PID, sample("write", "args->buf", "args->count"),
+		bpf.PID, sample("write", "args->buff", "args->len"),
+		bpf.PID)
+}
+
+type HTTPSample struct {
+	Time      time.Time
+	FD        int
+	Direction string
+	Length    int
+	Data      []byte
+}
+
+func parseHTTPSample(data string) (HTTPSample, bool) {
+	/*
+		Sample data:
+		procshave_http_sample	read	1234567890	7	77	GET /api/users/1 HTTP/1.1\x0d\x0aHost: example.com\x0d\x0a
+	*/
+	fields := strings.SplitN(strings.TrimRight(data, "\n"), "\t", 6)
+	if len(fields) != 6 || fields[0] != HTTPSampleTag {
+		return HTTPSample{}, false
+	}
+	sample := HTTPSample{
+		Direction: fields[1],
+		Time:      bpfTimestamp(parseBpfInt(fields[2])),
+		FD:        int(parseBpfInt(fields[3])),
+		Length:    int(parseBpfInt(fields[4])),
+		Data:      parseBpfBuffer(fields[5]),
+	}
+	// The sampled buffer is of a fixed size, the bytes beyond the payload length are garbage.
+	sample.Data = sample.Data[:min(len(sample.Data), sample.Length)]
+	return sample, true
+}
+
+// HTTPRoute turns a request path into a low cardinality route by dropping the query and replacing IDs with ":id".
+func HTTPRoute(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if HTTPRouteIDRegex.MatchString(segment) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+type httpPendingRequest struct {
+	Method string
+	Route  string
+	Time   time.Time
+	GRPC   bool
+}
+
+// httpConnection is the parsing state of a socket, the HPACK decoders are separate for each direction.
+type httpConnection struct {
+	pending    []*httpPendingRequest
+	h2Streams  map[uint32]*httpPendingRequest
+	h2Decoders map[string]*hpack.Decoder
+	// h2Lost tells the directions that have had bytes beyond the sample size, their decoders are out of sync with the
+	// dynamic table of the peer for the rest of the connection.
+	h2Lost map[string]bool
+}
+
+func newHTTPConnection() *httpConnection {
+	return &httpConnection{
+		h2Streams:  make(map[uint32]*httpPendingRequest),
+		h2Decoders: make(map[string]*hpack.Decoder),
+		h2Lost:     make(map[string]bool),
+	}
+}
+
+// h2Decoder returns the HPACK decoder of the direction. Once the direction has lost bytes, its decoder has no dynamic
+// table: the header blocks made of the static table and literals still decode, and those referring to the dynamic
+// table of the peer fail to decode rather than decoding into the wrong headers.
+func (conn *httpConnection) h2Decoder(direction string) *hpack.Decoder {
+	decoder := conn.h2Decoders[direction]
+	if decoder == nil {
+		if conn.h2Lost[direction] {
+			decoder = hpack.NewDecoder(0, nil)
+		} else {
+			decoder = hpack.NewDecoder(4096, nil)
+		}
+		conn.h2Decoders[direction] = decoder
+	}
+	return decoder
+}
+
+// loseH2Sync replaces the decoder of the direction by one without a dynamic table.
+func (conn *httpConnection) loseH2Sync(direction string) {
+	conn.h2Lost[direction] = true
+	delete(conn.h2Decoders, direction)
+}
+
+type HTTPRequestCounter struct {
+	Method   string
+	Route    string
+	Count    int
+	Duration time.Duration
+	Statuses map[string]int
+}
+
+type HTTPSummary struct {
+	ByCount []*HTTPRequestCounter
+}
+
+func (bpf *BpfTracer) handleHTTPSample(data string) {
+	sample, ok := parseHTTPSample(data)
+	if !ok {
+		return
+	}
+	bpf.mutex.Lock()
+	defer bpf.mutex.Unlock()
+	conn := bpf.httpConnections[sample.FD]
+	if conn == nil {
+		conn = newHTTPConnection()
+		bpf.httpConnections[sample.FD] = conn
+	}
+	payload := sample.Data
+	if bytes.HasPrefix(payload, []byte("PRI ")) {
+		// A new HTTP/2 connection may be reusing the FD of a closed one.
+		conn = newHTTPConnection()
+		bpf.httpConnections[sample.FD] = conn
+		payload = bytes.TrimPrefix(payload, []byte(h2Preface))
+	}
+	if method, path, ok := parseHTTP1RequestLine(payload); ok {
+		conn.pending = append(conn.pending, &httpPendingRequest{Method: method, Route: HTTPRoute(path), Time: sample.Time})
+		if len(conn.pending) > MaxHTTPPendingRequests {
+			conn.pending = conn.pending[1:]
+		}
+	} else if status, ok := parseHTTP1StatusLine(payload); ok {
+		if len(conn.pending) > 0 {
+			req := conn.pending[0]
+			conn.pending = conn.pending[1:]
+			bpf.recordHTTPRequest(req, status, sample.Time)
+		}
+	} else {
+		bpf.handleH2Frames(conn, sample, payload)
+		if sample.Length > len(sample.Data) {
+			// The frames beyond the sample are missed, along with the changes they make to the dynamic table.
+			conn.loseH2Sync(sample.Direction)
+		}
+	}
+}
+
+func parseHTTP1RequestLine(payload []byte) (method, path string, ok bool) {
+	line, _, _ := bytes.Cut(payload, []byte("\r\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || !HTTPMethods[fields[0]] || !strings.HasPrefix(fields[1], "/") {
+		return "", "", false
+	}
+	// The request line may be cut short by the sample size, in which case the route is incomplete but still useful.
+	return fields[0], fields[1], true
+}
+
+func parseHTTP1StatusLine(payload []byte) (status string, ok bool) {
+	line, _, _ := bytes.Cut(payload, []byte("\r\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/1.") || len(fields[1]) != 3 {
+		return "", false
+	}
+	if _, err := strconv.Atoi(fields[1]); err != nil {
+		return "", false
+	}
+	return fields[1], true
+}
+
+// handleH2Frames decodes the HEADERS frames in the sample. A header block cut short by the sample size is decoded as
+// far as it goes, which usually covers the pseudo headers at its beginning. The direction then loses the dynamic table,
+// see h2Decoder.
+func (bpf *BpfTracer) handleH2Frames(conn *httpConnection, sample HTTPSample, payload []byte) {
+	const (
+		frameHeaders      = 0x1
+		flagEndStream     = 0x1
+		flagPadded        = 0x8
+		flagPriority      = 0x20
+		frameHeaderLength = 9
+	)
+	for len(payload) >= frameHeaderLength {
+		length := int(payload[0])<<16 | int(payload[1])<<8 | int(payload[2])
+		frameType, flags := payload[3], payload[4]
+		streamID := binary.BigEndian.Uint32(payload[5:9]) & 0x7fffffff
+		truncated := frameHeaderLength+length > len(payload)
+		block := payload[frameHeaderLength:min(len(payload), frameHeaderLength+length)]
+		payload = payload[frameHeaderLength+len(block):]
+		if frameType != frameHeaders {
+			continue
+		}
+		if flags&flagPadded != 0 && len(block) > 0 {
+			if truncated {
+				block = block[1:]
+			} else {
+				block = block[1 : len(block)-min(int(block[0]), len(block)-1)]
+			}
+		}
+		if flags&flagPriority != 0 && len(block) >= 5 {
+			block = block[5:]
+		}
+		decoder, lost := conn.h2Decoder(sample.Direction), conn.h2Lost[sample.Direction]
+		headers := make(map[string]string)
+		decoder.SetEmitFunc(func(field hpack.HeaderField) {
+			headers[field.Name] = field.Value
+		})
+		_, err := decoder.Write(block)
+		if err == nil && !truncated {
+			err = decoder.Close()
+		}
+		if err != nil || truncated {
+			conn.loseH2Sync(sample.Direction)
+		}
+		if err != nil && !lost {
+			// The decoder was out of sync without telling, the headers decoded before the error may be wrong. Without a
+			// dynamic table, those headers are right and only the rest of the block is missing.
+			continue
+		}
+		if method, path := headers[":method"], headers[":path"]; method != "" && path != "" {
+			if len(conn.h2Streams) >= MaxHTTPPendingRequests {
+				conn.h2Streams = make(map[uint32]*httpPendingRequest)
+			}
+			conn.h2Streams[streamID] = &httpPendingRequest{
+				Method: method, Route: HTTPRoute(path), Time: sample.Time,
+				GRPC: strings.HasPrefix(headers["content-type"], "application/grpc"),
+			}
+			continue
+		}
+		req := conn.h2Streams[streamID]
+		if req == nil {
+			continue
+		}
+		// The content type of the request may have been cut off, the response tells a gRPC call apart just as well.
+		req.GRPC = req.GRPC || strings.HasPrefix(headers["content-type"], "application/grpc")
+		// The status of a gRPC call is in the trailers, which may come along with the response headers.
+		if status := headers["grpc-status"]; status != "" {
+			bpf.recordHTTPRequest(req, "grpc_"+status, sample.Time)
+			delete(conn.h2Streams, streamID)
+		} else if status := headers[":status"]; status != "" && (!req.GRPC || flags&flagEndStream != 0) {
+			bpf.recordHTTPRequest(req, status, sample.Time)
+			delete(conn.h2Streams, streamID)
+		}
+	}
+}
+
+// recordHTTPRequest must be called with the tracer mutex locked.
+func (bpf *BpfTracer) recordHTTPRequest(req *httpPendingRequest, status string, responseTime time.Time) {
+	method := req.Method
+	if !HTTPMethods[method] {
+		method = "OTHER"
+	}
+	route := req.Route
+	key := method + " " + route
+	if _, exists := bpf.httpRoutes[key]; !exists {
+		if len(bpf.httpRoutes) >= bpf.HTTPMaxRoutes {
+			route = HTTPOtherRoute
+			key = method + " " + route
+		} else {
+			bpf.httpRoutes[key] = true
+		}
+	}
+	duration := max(0, responseTime.Sub(req.Time))
+	counter := bpf.httpWindow[key]
+	if counter == nil {
+		counter = &HTTPRequestCounter{Method: method, Route: route, Statuses: make(map[string]int)}
+		bpf.httpWindow[key] = counter
+	}
+	counter.Count++
+	counter.Duration += duration
+	counter.Statuses[status]++
+	if bpf.Metrics != nil {
+		labels := prometheus.Labels{MethodLabel: method, RouteLabel: route}
+		bpf.Metrics.HTTPRequestDuration.With(labels).Observe(duration.Seconds())
+		labels[StatusLabel] = status
+		bpf.Metrics.HTTPRequestsTotal.With(labels).Inc()
+	}
+}
+
+// rotateHTTPWindow publishes the requests counted in the latest sampling interval, it must be called with the tracer
+// mutex locked.
+func (bpf *BpfTracer) rotateHTTPWindow() {
+	bpf.HTTPRequests = bpf.httpWindow
+	bpf.httpWindow = make(map[string]*HTTPRequestCounter)
+	// Forget the sockets that are no longer open.
+	openFDs := make(map[int]bool)
+	if entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", bpf.PID)); err == nil {
+		for _, entry := range entries {
+			fd, _ := strconv.Atoi(entry.Name())
+			openFDs[fd] = true
+		}
+		for fd := range bpf.httpConnections {
+			if !openFDs[fd] {
+				delete(bpf.httpConnections, fd)
+			}
+		}
+	}
+}
+
+func (bpf *BpfTracer) HTTPSummary() *HTTPSummary {
+	ret := &HTTPSummary{ByCount: []*HTTPRequestCounter{}}
+	for _, counter := range bpf.HTTPRequests {
+		ret.ByCount = append(ret.ByCount, counter)
+	}
+	sort.Slice(ret.ByCount, func(i, j int) bool {
+		return ret.ByCount[i].Count > ret.ByCount[j].Count
+	})
+	return ret
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	MaxLifecycleEvents = 200
+	LifecycleEventTag  = "procshave_lifecycle_event"
+)
+
+const (
+	LifecycleSignalGenerate = "signal_generate"
+	LifecycleSignalDeliver  = "signal_deliver"
+	LifecycleExit           = "exit"
+	LifecycleOOMKill        = "oom_kill"
+	LifecycleCoreDump       = "core_dump"
+)
+
+func (bpf *BpfTracer) lifecycleProbes() string {
+	/*
+		The children of the target are remembered when they are forked, so that their exit, OOM kill, and core dump are
+		shown along with those of the target itself. The fork tracepoint also fires for the new threads of the target,
+		which are forgotten when they exit, before their thread IDs may be reused as process IDs.
+	*/
+	code := fmt.Sprintf(`
+tracepoint:sched:sched_process_fork /pid == %d/ {
+    @child[args->child_pid] = 1;
+}
+tracepoint:signal:signal_generate /args->pid == %d || @child[args->pid]/ {
+    printf("%s\t%s\t%%d\t%%d\t%%s\t%%d\t%%s\t%%d\t%%d\n", nsecs, args->pid, args->comm, pid, comm, args->sig, args->result);
+}
+tracepoint:signal:signal_deliver /pid == %d || @child[pid]/ {
+    printf("%s\t%s\t%%d\t%%d\t%%s\t0\t\t%%d\t%%d\n", nsecs, pid, comm, args->sig, args->code);
+}
+tracepoint:sched:sched_process_exit /(pid == %d || @child[pid]) && pid == tid/ {
+    printf("%s\t%s\t%%d\t%%d\t%%s\t0\t\t%%d\t%%d\n", nsecs, pid, comm, curtask->exit_code & 0x7f, curtask->exit_code >> 8);
+}
+tracepoint:sched:sched_process_exit /@child[tid]/ {
+    delete(@child[tid]);
+}
+tracepoint:oom:mark_victim /args->pid == %d || @child[args->pid]/ {
+    printf("%s\t%s\t%%d\t%%d\t\t%%d\t%%s\t9\t0\n", nsecs, args->pid, pid, comm);
+}
+`, bpf.PID,
+		bpf.PID, LifecycleEventTag, LifecycleSignalGenerate,
+		bpf.PID, LifecycleEventTag, LifecycleSignalDeliver,
+		bpf.PID, LifecycleEventTag, LifecycleExit,
+		bpf.PID, LifecycleEventTag, LifecycleOOMKill)
+	// The kernel renamed do_coredump to vfs_coredump, bpftrace refuses the whole program if a probe cannot attach.
+	if coredump := FindKernelSymbol("vfs_coredump", "do_coredump"); coredump != "" {
+		code += fmt.Sprintf(`
+kprobe:%s /pid == %d || @child[pid]/ {
+    printf("%s\t%s\t%%d\t%%d\t%%s\t0\t\t0\t0\n", nsecs, pid, comm);
+}
+`, coredump, bpf.PID, LifecycleEventTag, LifecycleCoreDump)
+	} else {
+		log.Printf("Neither vfs_coredump nor do_coredump is in /proc/kallsyms, core dumps will not be shown.")
+	}
+	return code
+}
+
+// FindKernelSymbol returns the first of the kernel function names that exists in /proc/kallsyms, or "" if none does.
+func FindKernelSymbol(names ...string) string {
+	content, err := os.ReadFile("/proc/kallsyms")
+	if err != nil {
+		return ""
+	}
+	found := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		// Each line is "address type name [module]".
+		if fields := strings.Fields(line); len(fields) >= 3 && slices.Contains(names, fields[2]) {
+			found[fields[2]] = true
+		}
+	}
+	for _, name := range names {
+		if found[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+type LifecycleEvent struct {
+	Time       time.Time
+	Kind       string
+	PID        int
+	Comm       string
+	SenderPID  int
+	SenderComm string
+	Signal     int
+	// Code is the signal_generate result, the signal_deliver si_code, or the exit status.
+	Code int
+}
+
+func SignalName(sig int) string {
+	if name := unix.SignalName(syscall.Signal(sig)); name != "" {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", sig)
+}
+
+func bpfTimestamp(nsecs int64) time.Time {
+	var monoNow unix.Timespec
+	_ = unix.ClockGettime(unix.CLOCK_MONOTONIC, &monoNow)
+	return time.Now().Add(-time.Duration(monoNow.Nano() - nsecs))
+}
+
+func parseLifecycleEvent(data string) (LifecycleEvent, bool) {
+	/*
+		Sample data:
+		procshave_lifecycle_event	signal_generate	1234567890	4321	myapp	1	systemd	15	0
+		procshave_lifecycle_event	exit	1234567890	4321	myapp	0		9	0
+	*/
+	fields := strings.Split(strings.TrimRight(data, "\n"), "\t")
+	if len(fields) != 9 || fields[0] != LifecycleEventTag {
+		return LifecycleEvent{}, false
+	}
+	return LifecycleEvent{
+		Time:       bpfTimestamp(parseBpfInt(fields[2])),
+		Kind:       fields[1],
+		PID:        int(parseBpfInt(fields[3])),
+		Comm:       fields[4],
+		SenderPID:  int(parseBpfInt(fields[5])),
+		SenderComm: fields[6],
+		Signal:     int(parseBpfInt(fields[7])),
+		Code:       int(parseBpfInt(fields[8])),
+	}, true
+}
+
+func (evt LifecycleEvent) String() string {
+	var desc string
+	switch evt.Kind {
+	case LifecycleSignalGenerate:
+		desc = fmt.Sprintf("%s sent to %d %s by %d %s", SignalName(evt.Signal), evt.PID, evt.Comm, evt.SenderPID, evt.SenderComm)
+	case LifecycleSignalDeliver:
+		desc = fmt.Sprintf("%s delivered to %d %s", SignalName(evt.Signal), evt.PID, evt.Comm)
+	case LifecycleExit:
+		if evt.Signal != 0 {
+			desc = fmt.Sprintf("%d %s killed by %s", evt.PID, evt.Comm, SignalName(evt.Signal))
+		} else {
+			desc = fmt.Sprintf("%d %s exited with code %d", evt.PID, evt.Comm, evt.Code)
+		}
+	case LifecycleOOMKill:
+		desc = fmt.Sprintf("%d OOM killed, triggered by %d %s", evt.PID, evt.SenderPID, evt.SenderComm)
+	case LifecycleCoreDump:
+		desc = fmt.Sprintf("%d %s is dumping core", evt.PID, evt.Comm)
+	default:
+		desc = evt.Kind
+	}
+	return fmt.Sprintf("%s %s", evt.Time.Format("15:04:05.000"), desc)
+}
+
+func (bpf *BpfTracer) handleLifecycleEvent(data string) {
+	evt, ok := parseLifecycleEvent(data)
+	if !ok {
+		return
+	}
+	if evt.Kind == LifecycleSignalGenerate && bpf.Metrics != nil {
+		bpf.Metrics.SignalsTotal.With(prometheus.Labels{SignalLabel: SignalName(evt.Signal)}).Inc()
+	}
+	bpf.mutex.Lock()
+	bpf.LifecycleEvents = append(bpf.LifecycleEvents, evt)
+	if len(bpf.LifecycleEvents) > MaxLifecycleEvents {
+		bpf.LifecycleEvents = bpf.LifecycleEvents[len(bpf.LifecycleEvents)-MaxLifecycleEvents:]
+	}
+	onEvent := bpf.OnLifecycleEvent
+	bpf.mutex.Unlock()
+	if onEvent != nil {
+		onEvent(evt)
+	}
+}
+
+func (bpf *BpfTracer) LatestLifecycleEvents(count int) []LifecycleEvent {
+	bpf.mutex.Lock()
+	defer bpf.mutex.Unlock()
+	ret := make([]LifecycleEvent, 0, count)
+	ret = append(ret, bpf.LifecycleEvents[max(0, len(bpf.LifecycleEvents)-count):]...)
+	return ret
+}
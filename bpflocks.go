@@ -0,0 +1,184 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// GoMutexSlowPathSymbols are only called when a sync.Mutex is already locked by someone else.
+	GoMutexSlowPathSymbols = []string{"sync.(*Mutex).lockSlow", "internal/sync.(*Mutex).lockSlow"}
+)
+
+// FindGoMutexSymbols returns the sync.Mutex slow path symbols present in the executable of the process.
+// The return value is empty if the process is not a Go program or its symbol table is stripped.
+func FindGoMutexSymbols(pid int) []string {
+	exe, err := elf.Open(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return nil
+	}
+	defer exe.Close()
+	symbols, err := exe.Symbols()
+	if err != nil {
+		return nil
+	}
+	var ret []string
+	for _, symbol := range symbols {
+		for _, name := range GoMutexSlowPathSymbols {
+			if symbol.Name == name {
+				ret = append(ret, name)
+			}
+		}
+	}
+	return ret
+}
+
+func (bpf *BpfTracer) lockProbes() string {
+	// Only the waiting futex operations are traced: FUTEX_WAIT (0), FUTEX_LOCK_PI (6), and FUTEX_WAIT_BITSET (9).
+	ret := fmt.Sprintf(`
+tracepoint:syscalls:sys_enter_futex /pid == %d && ((args->op & 127) == 0 || (args->op & 127) == 6 || (args->op & 127) == 9)/ {
+    @futex_start[tid] = nsecs;
+    @futex_uaddr[tid] = (uint64)args->uaddr;
+}
+tracepoint:syscalls:sys_exit_futex /pid == %d && @futex_start[tid]/ {
+    $wait = nsecs - @futex_start[tid];
+    $uaddr = @futex_uaddr[tid];
+    @futex_wait_nanos[$uaddr] += $wait;
+    @futex_wait_count[$uaddr] = count();
+    @futex_max_wait[$uaddr] = max($wait);
+    @futex_waiters[$uaddr, tid] = 1;
+    @futex_stack_nanos[ustack(5)] += $wait;
+    delete(@futex_start[tid]);
+    delete(@futex_uaddr[tid]);
+}
+`, bpf.PID, bpf.PID)
+	for _, symbol := range bpf.GoMutexSymbols {
+		ret += fmt.Sprintf(`
+uprobe:/proc/%d/exe:"%s" {
+    @gomutex_contended[ustack(6)] = count();
+}
+`, bpf.PID, symbol)
+	}
+	return ret
+}
+
+func (bpf *BpfTracer) lockIntervalStatements() string {
+	ret := `
+    print(@futex_wait_nanos); print(@futex_wait_count); print(@futex_max_wait); print(@futex_waiters); print(@futex_stack_nanos);
+    clear(@futex_wait_nanos); clear(@futex_wait_count); clear(@futex_max_wait); clear(@futex_waiters); clear(@futex_stack_nanos);`
+	if len(bpf.GoMutexSymbols) > 0 {
+		ret += `
+    print(@gomutex_contended); clear(@gomutex_contended);`
+	}
+	return ret
+}
+
+type FutexCounter struct {
+	Address   uint64
+	WaitCount int
+	Waiters   int
+	WaitTime  time.Duration
+	// MaxWait is the longest single wait on the futex. How long the lock is held is not seen by the futex syscalls, only
+	// how long its waiters wait for it.
+	MaxWait time.Duration
+}
+
+type StackCounter struct {
+	Stack    string
+	Count    int
+	WaitTime time.Duration
+}
+
+type LockSummary struct {
+	ByAddress      map[uint64]*FutexCounter
+	ByWaitTime     []*FutexCounter
+	ByStack        []*StackCounter
+	GoMutexByCount []*StackCounter
+}
+
+// StackCaption turns a multi-line bpftrace stack into a single line, innermost frame first.
+func StackCaption(stack string, maxFrames int) string {
+	var frames []string
+	for _, frame := range strings.Split(stack, "\n") {
+		frame = strings.TrimSpace(frame)
+		if frame == "" {
+			continue
+		}
+		// Drop the offset from the frames like "main.worker+123".
+		if plus := strings.LastIndexByte(frame, '+'); plus > 0 {
+			frame = frame[:plus]
+		}
+		frames = append(frames, frame)
+		if len(frames) == maxFrames {
+			break
+		}
+	}
+	return strings.Join(frames, " < ")
+}
+
+func (bpf *BpfTracer) LockSummary() *LockSummary {
+	ret := &LockSummary{
+		ByAddress:      make(map[uint64]*FutexCounter),
+		ByWaitTime:     []*FutexCounter{},
+		ByStack:        []*StackCounter{},
+		GoMutexByCount: []*StackCounter{},
+	}
+	getCounter := func(addrStr string) *FutexCounter {
+		addr, err := strconv.ParseUint(addrStr, 10, 64)
+		if err != nil {
+			return nil
+		}
+		if _, exists := ret.ByAddress[addr]; !exists {
+			ret.ByAddress[addr] = &FutexCounter{Address: addr}
+		}
+		return ret.ByAddress[addr]
+	}
+	for addr, nanos := range bpf.FutexWaitNanos {
+		if counter := getCounter(addr); counter != nil {
+			counter.WaitTime = time.Duration(nanos) * time.Nanosecond
+		}
+	}
+	for addr, count := range bpf.FutexWaitCount {
+		if counter := getCounter(addr); counter != nil {
+			counter.WaitCount = count
+		}
+	}
+	for addr, nanos := range bpf.FutexMaxWait {
+		if counter := getCounter(addr); counter != nil {
+			counter.MaxWait = time.Duration(nanos) * time.Nanosecond
+		}
+	}
+	/*
+		Sample data, the key consists of futex address and waiter thread ID:
+		{"type": "map", "data": {"@futex_waiters": {"824634335560,4321": 1, "824634335560,4322": 1}}}
+	*/
+	for addrTid := range bpf.FutexWaiters {
+		addr, _, _ := strings.Cut(addrTid, ",")
+		if counter := getCounter(addr); counter != nil {
+			counter.Waiters++
+		}
+	}
+	for _, counter := range ret.ByAddress {
+		ret.ByWaitTime = append(ret.ByWaitTime, counter)
+	}
+	sort.Slice(ret.ByWaitTime, func(i, j int) bool {
+		return ret.ByWaitTime[i].WaitTime > ret.ByWaitTime[j].WaitTime
+	})
+	for stack, nanos := range bpf.FutexStackNanos {
+		ret.ByStack = append(ret.ByStack, &StackCounter{Stack: stack, WaitTime: time.Duration(nanos) * time.Nanosecond})
+	}
+	sort.Slice(ret.ByStack, func(i, j int) bool {
+		return ret.ByStack[i].WaitTime > ret.ByStack[j].WaitTime
+	})
+	for stack, count := range bpf.GoMutexContended {
+		ret.GoMutexByCount = append(ret.GoMutexByCount, &StackCounter{Stack: stack, Count: count})
+	}
+	sort.Slice(ret.GoMutexByCount, func(i, j int) bool {
+		return ret.GoMutexByCount[i].Count > ret.GoMutexByCount[j].Count
+	})
+	return ret
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+const (
+	MaxTLSEvents = 200
+	TLSEventTag  = "procshave_tls_event"
+	// MaxTLSCaptureBytes is the default string and buffer length limit of bpftrace.
+	MaxTLSCaptureBytes = 64
+	// TLSPlaintextWarning is shown in the terminal UI and printed in headless mode while -tls is on.
+	TLSPlaintextWarning = "-tls attaches uprobes to the TLS library of the process to read its decrypted traffic. " +
+		"The captured plaintext may contain credentials and personal data, it is shown on screen and printed in headless mode."
+)
+
+const (
+	TLSLibraryOpenSSL = "openssl"
+	TLSLibraryGo      = "go"
+)
+
+var (
+	// GoTLSSymbols are the Go crypto/tls methods that carry the plaintext to and from the application.
+	GoTLSSymbols = []string{"crypto/tls.(*Conn).Read", "crypto/tls.(*Conn).Write"}
+	// TLSCloseSymbols are the functions that end a connection in each TLS library, the connection is their first
+	// argument.
+	TLSCloseSymbols = map[string][]string{
+		TLSLibraryOpenSSL: {"SSL_shutdown", "SSL_free"},
+		TLSLibraryGo:      {"crypto/tls.(*Conn).Close"},
+	}
+	// TLSSyscalls are the syscalls used by TLS libraries to move the encrypted bytes through a socket.
+	TLSSyscalls = []string{"read", "write", "recvfrom", "sendto"}
+)
+
+// goRegisterABI names the bpftrace registers of the Go internal ABI for the first arguments, the first return value,
+// and the current goroutine.
+type goRegisterABI struct {
+	Receiver, BufPtr, Ret, Goroutine string
+}
+
+var goRegisterABIs = map[string]goRegisterABI{
+	"amd64": {Receiver: "ax", BufPtr: "bx", Ret: "ax", Goroutine: "r14"},
+	"arm64": {Receiver: "r0", BufPtr: "r1", Ret: "r0", Goroutine: "r28"},
+}
+
+type TLSProbeTarget struct {
+	Library string
+	// Path is the executable or shared library to attach the uprobes to, as seen from procshave.
+	Path string
+	// GoReturnAddrs are the addresses of the return instructions of each Go TLS symbol, which stand in for uretprobes
+	// as those crash Go programs when a goroutine stack moves.
+	GoReturnAddrs map[string][]uint64
+	// CloseSymbols are those of TLSCloseSymbols found in Path.
+	CloseSymbols []string
+}
+
+// FindTLSProbeTargets returns the OpenSSL (or BoringSSL) libraries loaded by the process, and the process executable
+// itself if it is a Go program using crypto/tls or statically links an SSL library.
+func FindTLSProbeTargets(pid int) []TLSProbeTarget {
+	var ret []TLSProbeTarget
+	exePath := fmt.Sprintf("/proc/%d/exe", pid)
+	if hasSSLSymbols(exePath) {
+		ret = append(ret, TLSProbeTarget{Library: TLSLibraryOpenSSL, Path: exePath, CloseSymbols: findFunctions(exePath, TLSCloseSymbols[TLSLibraryOpenSSL])})
+	}
+	if addrs := findGoTLSReturnAddrs(exePath); len(addrs) == len(GoTLSSymbols) {
+		ret = append(ret, TLSProbeTarget{Library: TLSLibraryGo, Path: exePath, GoReturnAddrs: addrs, CloseSymbols: findFunctions(exePath, TLSCloseSymbols[TLSLibraryGo])})
+	}
+	maps, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return ret
+	}
+	defer maps.Close()
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(maps)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || !strings.HasPrefix(filepath.Base(fields[5]), "libssl.so") || seen[fields[5]] {
+			continue
+		}
+		seen[fields[5]] = true
+		// Look into the mount namespace of the process, the library may be inside a container.
+		libPath := fmt.Sprintf("/proc/%d/root%s", pid, fields[5])
+		if hasSSLSymbols(libPath) {
+			ret = append(ret, TLSProbeTarget{Library: TLSLibraryOpenSSL, Path: libPath, CloseSymbols: findFunctions(libPath, TLSCloseSymbols[TLSLibraryOpenSSL])})
+		}
+	}
+	return ret
+}
+
+func hasSSLSymbols(path string) bool {
+	return len(findFunctions(path, []string{"SSL_read", "SSL_write"})) == 2
+}
+
+// findFunctions returns those of the named functions defined in the ELF file, as attaching a uprobe to a missing one
+// fails the whole script.
+func findFunctions(path string, names []string) []string {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	found := make(map[string]bool)
+	dynSymbols, _ := file.DynamicSymbols()
+	symbols, _ := file.Symbols()
+	for _, symbol := range append(dynSymbols, symbols...) {
+		if slices.Contains(names, symbol.Name) && elf.ST_TYPE(symbol.Info) == elf.STT_FUNC && symbol.Value != 0 {
+			found[symbol.Name] = true
+		}
+	}
+	var ret []string
+	for _, name := range names {
+		if found[name] {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// findGoTLSReturnAddrs disassembles the Go TLS symbols to find their return instructions.
+// The return value is empty if the executable is not a Go program using crypto/tls, or it cannot be disassembled.
+func findGoTLSReturnAddrs(exePath string) map[string][]uint64 {
+	if _, supported := goRegisterABIs[runtime.GOARCH]; !supported {
+		return nil
+	}
+	file, err := elf.Open(exePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	text := file.Section(".text")
+	if text == nil {
+		return nil
+	}
+	code, err := text.Data()
+	if err != nil {
+		return nil
+	}
+	symbols, _ := file.Symbols()
+	ret := make(map[string][]uint64)
+	for _, symbol := range symbols {
+		for _, name := range GoTLSSymbols {
+			if symbol.Name != name || symbol.Value < text.Addr || symbol.Value+symbol.Size > text.Addr+uint64(len(code)) {
+				continue
+			}
+			offsets := returnInstructionOffsets(code[symbol.Value-text.Addr : symbol.Value-text.Addr+symbol.Size])
+			if len(offsets) == 0 {
+				return nil
+			}
+			for _, offset := range offsets {
+				ret[name] = append(ret[name], symbol.Value+offset)
+			}
+		}
+	}
+	return ret
+}
+
+// returnInstructionOffsets returns nil if any instruction fails to decode, as a uprobe placed in the middle of an
+// instruction corrupts the program.
+func returnInstructionOffsets(code []byte) []uint64 {
+	var ret []uint64
+	switch runtime.GOARCH {
+	case "amd64":
+		for offset := 0; offset < len(code); {
+			inst, err := x86asm.Decode(code[offset:], 64)
+			if err != nil {
+				return nil
+			}
+			if inst.Op == x86asm.RET {
+				ret = append(ret, uint64(offset))
+			}
+			offset += inst.Len
+		}
+	case "arm64":
+		for offset := 0; offset+4 <= len(code); offset += 4 {
+			// RET (to X30) has a fixed encoding.
+			if binary.LittleEndian.Uint32(code[offset:]) == 0xd65f03c0 {
+				ret = append(ret, uint64(offset))
+			}
+		}
+	}
+	return ret
+}
+
+func (bpf *BpfTracer) tlsCaptureStatement(direction, connExpr, bufExpr string) string {
+	if bpf.TLSCaptureBytes == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`printf("%s\t%s\t%%d\t%%d\t%%d\t%%d\t%%r\n", nsecs, tid, @tls_conn_fd[%s] - 1, $n, buf(%s, %d));`,
+		TLSEventTag, direction, connExpr, bufExpr, bpf.TLSCaptureBytes)
+}
+
+func (bpf *BpfTracer) tlsProbes() string {
+	if len(bpf.TLSTargets) == 0 {
+		return ""
+	}
+	/*
+		The plaintext is attributed to a connection by the socket FD (stored as FD+1 so that 0 means unknown) that the
+		TLS library reads from or writes to during the call. A connection is keyed by the SSL or tls.Conn pointer.
+		The FD of a Go connection is best-effort as the goroutine may park in the call and let another one run on the
+		same thread, hence the first FD seen is kept.
+		@tls_fd_conn maps the FD back to the connection, so that the FD is forgotten once either of them is closed. A
+		later connection at the same address or on the same FD then finds its own FD.
+	*/
+	var ret string
+	for _, name := range TLSSyscalls {
+		ret += fmt.Sprintf(`
+tracepoint:syscalls:sys_enter_%s /pid == %d && @tls_thread_conn[tid] && !@tls_conn_fd[@tls_thread_conn[tid]]/ {
+    $conn = @tls_thread_conn[tid];
+    @tls_conn_fd[$conn] = (int64)args->fd + 1;
+    @tls_fd_conn[@tls_conn_fd[$conn]] = $conn;
+}
+`, name, bpf.PID)
+	}
+	ret += fmt.Sprintf(`
+tracepoint:syscalls:sys_enter_close /pid == %d && @tls_fd_conn[(int64)args->fd + 1]/ {
+    delete(@tls_conn_fd[@tls_fd_conn[(int64)args->fd + 1]]);
+    delete(@tls_fd_conn[(int64)args->fd + 1]);
+}
+`, bpf.PID)
+	forget := func(connExpr string) string {
+		return fmt.Sprintf(`
+    delete(@tls_fd_conn[@tls_conn_fd[%s]]);
+    delete(@tls_conn_fd[%s]);`, connExpr, connExpr)
+	}
+	for _, target := range bpf.TLSTargets {
+		switch target.Library {
+		case TLSLibraryOpenSSL:
+			for _, direction := range []string{"read", "write"} {
+				ret += fmt.Sprintf(`
+uprobe:%s:SSL_%s /pid == %d/ {
+    @tls_thread_conn[tid] = arg0;
+    @tls_buf[tid] = arg1;
+}
+uretprobe:%s:SSL_%s /pid == %d && @tls_thread_conn[tid]/ {
+    $conn = @tls_thread_conn[tid];
+    $n = (int32)retval;
+    if ($n > 0) {
+        @tls_%s_bytes[@tls_conn_fd[$conn] - 1] += $n;
+        %s
+    }
+    delete(@tls_thread_conn[tid]);
+    delete(@tls_buf[tid]);
+}
+`, target.Path, direction, bpf.PID,
+					target.Path, direction, bpf.PID, direction, bpf.tlsCaptureStatement(direction, "$conn", "@tls_buf[tid]"))
+			}
+			for _, symbol := range target.CloseSymbols {
+				ret += fmt.Sprintf(`
+uprobe:%s:%s /pid == %d/ {%s
+}
+`, target.Path, symbol, bpf.PID, forget("arg0"))
+			}
+		case TLSLibraryGo:
+			regs := goRegisterABIs[runtime.GOARCH]
+			for i, symbol := range GoTLSSymbols {
+				direction := []string{"read", "write"}[i]
+				ret += fmt.Sprintf(`
+uprobe:%s:"%s" /pid == %d/ {
+    @tls_go_conn[reg("%s")] = reg("%s");
+    @tls_go_buf[reg("%s")] = reg("%s");
+    @tls_thread_conn[tid] = reg("%s");
+}
+`, target.Path, symbol, bpf.PID, regs.Goroutine, regs.Receiver, regs.Goroutine, regs.BufPtr, regs.Receiver)
+				for _, addr := range target.GoReturnAddrs[symbol] {
+					ret += fmt.Sprintf(`
+uprobe:%s:%#x /pid == %d && @tls_go_conn[reg("%s")]/ {
+    $conn = @tls_go_conn[reg("%s")];
+    $n = (int64)reg("%s");
+    if ($n > 0) {
+        @tls_%s_bytes[@tls_conn_fd[$conn] - 1] += $n;
+        %s
+    }
+    delete(@tls_go_conn[reg("%s")]);
+    delete(@tls_go_buf[reg("%s")]);
+    delete(@tls_thread_conn[tid]);
+}
+`, target.Path, addr, bpf.PID, regs.Goroutine, regs.Goroutine, regs.Ret,
+						direction, bpf.tlsCaptureStatement(direction, "$conn", fmt.Sprintf(`@tls_go_buf[reg("%s")]`, regs.Goroutine)),
+						regs.Goroutine, regs.Goroutine)
+				}
+			}
+			for _, symbol := range target.CloseSymbols {
+				ret += fmt.Sprintf(`
+uprobe:%s:"%s" /pid == %d/ {%s
+}
+`, target.Path, symbol, bpf.PID, forget(fmt.Sprintf(`reg("%s")`, regs.Receiver)))
+			}
+		}
+	}
+	return ret
+}
+
+func (bpf *BpfTracer) tlsIntervalStatements() string {
+	if len(bpf.TLSTargets) == 0 {
+		return ""
+	}
+	return `
+    print(@tls_read_bytes); print(@tls_write_bytes);
+    clear(@tls_read_bytes); clear(@tls_write_bytes);`
+}
+
+type TLSConnCounter struct {
+	// FD is -1 if the socket of the connection is unknown.
+	FD         int
+	Socket     *SocketInfo
+	ReadBytes  int
+	WriteBytes int
+}
+
+func (counter *TLSConnCounter) Caption() string {
+	if counter.Socket != nil {
+		return counter.Socket.RemoteCaption()
+	} else if counter.FD >= 0 {
+		return fmt.Sprintf("fd %d", counter.FD)
+	}
+	return "unknown connection"
+}
+
+type TLSSummary struct {
+	ByBytes []*TLSConnCounter
+}
+
+// TLSSummary returns the plaintext byte counts of each TLS connection, the sockets are looked up by the FD paths.
+func (bpf *BpfTracer) TLSSummary(fdPaths map[int]string, sockets map[uint64]*SocketInfo) *TLSSummary {
+	byFD := make(map[int]*TLSConnCounter)
+	getCounter := func(fdStr string) *TLSConnCounter {
+		fd := int(parseBpfInt(fdStr))
+		if _, exists := byFD[fd]; !exists {
+			byFD[fd] = &TLSConnCounter{FD: fd}
+			if inode, ok := SocketInode(fdPaths[fd]); ok {
+				byFD[fd].Socket = sockets[inode]
+			}
+		}
+		return byFD[fd]
+	}
+	for fd, count := range bpf.TLSReadBytes {
+		getCounter(fd).ReadBytes += count
+	}
+	for fd, count := range bpf.TLSWriteBytes {
+		getCounter(fd).WriteBytes += count
+	}
+	ret := &TLSSummary{ByBytes: []*TLSConnCounter{}}
+	for _, counter := range byFD {
+		ret.ByBytes = append(ret.ByBytes, counter)
+	}
+	sort.Slice(ret.ByBytes, func(i, j int) bool {
+		return ret.ByBytes[i].ReadBytes+ret.ByBytes[i].WriteBytes > ret.ByBytes[j].ReadBytes+ret.ByBytes[j].WriteBytes
+	})
+	return ret
+}
+
+type TLSEvent struct {
+	Time      time.Time
+	TID       int
+	FD        int
+	Direction string
+	Length    int
+	// Data is the beginning of the plaintext, at most TLSCaptureBytes long.
+	Data []byte
+}
+
+func parseTLSEvent(data string, captureBytes int) (TLSEvent, bool) {
+	/*
+		Sample data:
+		procshave_tls_event	read	1234567890	4321	7	77	GET / HTTP/1.1\x0d\x0aHost: example.com\x0d\x0a
+	*/
+	fields := strings.SplitN(strings.TrimRight(data, "\n"), "\t", 7)
+	if len(fields) != 7 || fields[0] != TLSEventTag {
+		return TLSEvent{}, false
+	}
+	evt := TLSEvent{
+		Direction: fields[1],
+		Time:      bpfTimestamp(parseBpfInt(fields[2])),
+		TID:       int(parseBpfInt(fields[3])),
+		FD:        int(parseBpfInt(fields[4])),
+		Length:    int(parseBpfInt(fields[5])),
+		Data:      parseBpfBuffer(fields[6]),
+	}
+	// The captured buffer is of a fixed size, the bytes beyond the plaintext length are garbage.
+	evt.Data = evt.Data[:min(len(evt.Data), evt.Length, captureBytes)]
+	return evt, true
+}
+
+func (evt TLSEvent) Format(fdPaths map[int]string) string {
+	return fmt.Sprintf("%s %-7d TLS %s(%s) = %d %q", evt.Time.Format("15:04:05.000000"), evt.TID, evt.Direction, fdCaption(int64(evt.FD), fdPaths), evt.Length, evt.Data)
+}
+
+func (bpf *BpfTracer) handleTLSEvent(data string) {
+	evt, ok := parseTLSEvent(data, bpf.TLSCaptureBytes)
+	if !ok {
+		return
+	}
+	bpf.mutex.Lock()
+	bpf.TLSEvents = append(bpf.TLSEvents, evt)
+	if len(bpf.TLSEvents) > MaxTLSEvents {
+		bpf.TLSEvents = bpf.TLSEvents[len(bpf.TLSEvents)-MaxTLSEvents:]
+	}
+	onEvent := bpf.OnTLSEvent
+	bpf.mutex.Unlock()
+	if onEvent != nil {
+		onEvent(evt)
+	}
+}
+
+func (bpf *BpfTracer) LatestTLSEvents(count int) []TLSEvent {
+	bpf.mutex.Lock()
+	defer bpf.mutex.Unlock()
+	ret := make([]TLSEvent, 0, count)
+	ret = append(ret, bpf.TLSEvents[max(0, len(bpf.TLSEvents)-count):]...)
+	return ret
+}
@@ -13,10 +13,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/procfs/blockdevice"
+	"golang.org/x/sys/unix"
 )
 
 var (
@@ -28,6 +29,23 @@ type BpfMapRecord struct {
 	Data map[string]map[string]int `json:"data"`
 }
 
+// BpfHistBucket is a bucket of a bpftrace hist() map, the boundaries are inclusive.
+type BpfHistBucket struct {
+	Min   *int `json:"min"`
+	Max   *int `json:"max"`
+	Count int  `json:"count"`
+}
+
+type BpfHistRecord struct {
+	Type string                                `json:"type"`
+	Data map[string]map[string][]BpfHistBucket `json:"data"`
+}
+
+type BpfPrintfRecord struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
 type BpfNetIOTrafficCounter struct {
 	IP          net.IP
 	Port        int
@@ -35,6 +53,10 @@ type BpfNetIOTrafficCounter struct {
 	IsDest      bool
 }
 
+func (counter BpfNetIOTrafficCounter) Endpoint() string {
+	return net.JoinHostPort(counter.IP.String(), strconv.Itoa(counter.Port))
+}
+
 func TcpTrafficFromBpfMap(bpfMap map[string]int, isDest bool) []BpfNetIOTrafficCounter {
 	/*
 		Sample data for localhost communication:
@@ -93,17 +115,131 @@ type BpfTracer struct {
 	FDBytesWritten   map[string]int
 	FDBytesWrittenTS time.Time
 
+	// FDLatencyHist is the histogram of read and write latency in microseconds by FD.
+	FDLatencyHist   map[string][]BpfHistBucket
+	FDLatencyHistTS time.Time
+
 	TcpTrafficSources   []BpfNetIOTrafficCounter
 	TcpTrafficSourcesTS time.Time
 
 	TcpTrafficDestinations   []BpfNetIOTrafficCounter
 	TcpTrafficDestinationsTS time.Time
 
-	BlockDeviceIONanos   map[string]int
-	BlockDeviceIONanosTS time.Time
+	SocketSendBuf   map[string]int
+	SocketSendBufTS time.Time
+
+	SocketRecvBuf   map[string]int
+	SocketRecvBufTS time.Time
+
+	SkbDropReasons map[int]string
+	SkbDrops       map[string]int
+	SkbDropsTS     time.Time
+
+	ListenOverflow   map[string]int
+	ListenOverflowTS time.Time
+
+	Accepts   map[string]int
+	AcceptsTS time.Time
+
+	AcceptFirstByteHist   map[string][]BpfHistBucket
+	AcceptFirstByteHistTS time.Time
+
+	ListenMaxBacklog   map[string]int
+	ListenMaxBacklogTS time.Time
+
+	BlockIOSectors   map[string]int
+	BlockIOSectorsTS time.Time
+
+	BlockIOOps   map[string]int
+	BlockIOOpsTS time.Time
+
+	BlockIOQueueNanos   map[string]int
+	BlockIOQueueNanosTS time.Time
+
+	BlockIOServiceNanos   map[string]int
+	BlockIOServiceNanosTS time.Time
+
+	BlockIOServiceHist   map[string][]BpfHistBucket
+	BlockIOServiceHistTS time.Time
+
+	BlockIOFileNanos   map[string]int
+	BlockIOFileNanosTS time.Time
+
+	BlockIOFileSectors   map[string]int
+	BlockIOFileSectorsTS time.Time
+
+	WritebackFileNanos   map[string]int
+	WritebackFileNanosTS time.Time
+
+	WritebackFileSectors   map[string]int
+	WritebackFileSectorsTS time.Time
+
+	DirtiedPages   map[string]int
+	DirtiedPagesTS time.Time
 
-	BlockDeviceIOSectors   map[string]int
-	BlockDeviceIOSectorsTS time.Time
+	DirtyThrottleMillis   map[string]int
+	DirtyThrottleMillisTS time.Time
+
+	SyscallCount   map[string]int
+	SyscallCountTS time.Time
+
+	SyscallNanos   map[string]int
+	SyscallNanosTS time.Time
+
+	SyscallErrors   map[string]int
+	SyscallErrorsTS time.Time
+
+	PageCacheMisses   map[string]int
+	PageCacheMissesTS time.Time
+
+	EventSyscalls   []int
+	EventErrorsOnly bool
+	SyscallEvents   []SyscallEvent
+	OnSyscallEvent  func(SyscallEvent)
+
+	LifecycleEvents  []LifecycleEvent
+	OnLifecycleEvent func(LifecycleEvent)
+
+	FutexWaitNanos   map[string]int
+	FutexWaitNanosTS time.Time
+
+	FutexWaitCount   map[string]int
+	FutexWaitCountTS time.Time
+
+	FutexMaxWait   map[string]int
+	FutexMaxWaitTS time.Time
+
+	FutexWaiters   map[string]int
+	FutexWaitersTS time.Time
+
+	FutexStackNanos   map[string]int
+	FutexStackNanosTS time.Time
+
+	GoMutexSymbols     []string
+	GoMutexContended   map[string]int
+	GoMutexContendedTS time.Time
+
+	TLSTargets      []TLSProbeTarget
+	TLSCaptureBytes int
+	TLSEvents       []TLSEvent
+	OnTLSEvent      func(TLSEvent)
+
+	TLSReadBytes   map[string]int
+	TLSReadBytesTS time.Time
+
+	TLSWriteBytes   map[string]int
+	TLSWriteBytesTS time.Time
+
+	// FilterPaths and FilterNets narrow the file IO and TCP traffic probes down to the path prefixes and networks.
+	FilterPaths []string
+	FilterNets  []*net.IPNet
+
+	HTTPEnabled     bool
+	HTTPMaxRoutes   int
+	HTTPRequests    map[string]*HTTPRequestCounter
+	httpWindow      map[string]*HTTPRequestCounter
+	httpRoutes      map[string]bool
+	httpConnections map[int]*httpConnection
 }
 
 func NewBpfTracer(pid int, samplingIntervalSec int, metrics *MetricsCollector) *BpfTracer {
@@ -114,49 +250,106 @@ func NewBpfTracer(pid int, samplingIntervalSec int, metrics *MetricsCollector) *
 		SamplingIntervalSec:  samplingIntervalSec,
 		FDBytesRead:          make(map[string]int),
 		FDBytesWritten:       make(map[string]int),
-		BlockDeviceIONanos:   make(map[string]int),
-		BlockDeviceIOSectors: make(map[string]int),
+		SocketSendBuf:        make(map[string]int),
+		SocketRecvBuf:        make(map[string]int),
+		SkbDropReasons:       ReadSkbDropReasons(),
+		SkbDrops:             make(map[string]int),
+		ListenOverflow:       make(map[string]int),
+		Accepts:              make(map[string]int),
+		AcceptFirstByteHist:  make(map[string][]BpfHistBucket),
+		ListenMaxBacklog:     make(map[string]int),
+		BlockIOSectors:       make(map[string]int),
+		BlockIOOps:           make(map[string]int),
+		BlockIOQueueNanos:    make(map[string]int),
+		BlockIOServiceNanos:  make(map[string]int),
+		BlockIOServiceHist:   make(map[string][]BpfHistBucket),
+		BlockIOFileNanos:     make(map[string]int),
+		BlockIOFileSectors:   make(map[string]int),
+		WritebackFileNanos:   make(map[string]int),
+		WritebackFileSectors: make(map[string]int),
+		DirtiedPages:         make(map[string]int),
+		DirtyThrottleMillis:  make(map[string]int),
+		SyscallCount:         make(map[string]int),
+		SyscallNanos:         make(map[string]int),
+		SyscallErrors:        make(map[string]int),
+		PageCacheMisses:      make(map[string]int),
+		FutexWaitNanos:       make(map[string]int),
+		FutexWaitCount:       make(map[string]int),
+		FutexMaxWait:         make(map[string]int),
+		FutexWaiters:         make(map[string]int),
+		FutexStackNanos:      make(map[string]int),
+		GoMutexContended:     make(map[string]int),
+		TLSReadBytes:         make(map[string]int),
+		TLSWriteBytes:        make(map[string]int),
+		HTTPRequests:         make(map[string]*HTTPRequestCounter),
+		httpWindow:           make(map[string]*HTTPRequestCounter),
+		httpRoutes:           make(map[string]bool),
+		httpConnections:      make(map[int]*httpConnection),
 		Metrics:              metrics,
 	}
 }
 
 func (bpf *BpfTracer) Start() error {
 	code := fmt.Sprintf(`
-tracepoint:syscalls:sys_enter_read /pid == %d/ {
+tracepoint:syscalls:sys_enter_read /pid == %d%s/ {
 	@fd[tid] = args->fd;
+	@fd_start[tid] = nsecs;
+	@reading[tid] = 1;
 }
 tracepoint:syscalls:sys_exit_read /pid == %d && @fd[tid]/ {
     if (args->ret > 0) {@read_fd[@fd[tid]] += args->ret;}
+    @fd_latency_hist[@fd[tid]] = hist((nsecs - @fd_start[tid]) / 1000);
     delete(@fd[tid]);
+    delete(@fd_start[tid]);
+    delete(@reading[tid]);
 }
-tracepoint:syscalls:sys_enter_write /pid == %d/ {
+tracepoint:syscalls:sys_enter_write /pid == %d%s/ {
     @fd[tid] = args->fd;
+    @fd_start[tid] = nsecs;
 }
 tracepoint:syscalls:sys_exit_write /pid == %d && @fd[tid]/ {
     if (args->ret > 0) {@write_fd[@fd[tid]] += args->ret;}
+    @fd_latency_hist[@fd[tid]] = hist((nsecs - @fd_start[tid]) / 1000);
     delete(@fd[tid]);
+    delete(@fd_start[tid]);
 }
-tracepoint:tcp:tcp_probe /pid == %d/ {
+tracepoint:tcp:tcp_probe /pid == %d%s/ {
     @tcp_src[args->saddr, args->sport] += args->data_len;
     @tcp_dest[args->daddr, args->dport] += args->data_len;
 }
-tracepoint:block:block_io_start /pid == %d/ {
-    @blkdev_sector_count[args->dev] += args->nr_sector;
-    @blkdev_req[args->sector] = nsecs;
+tracepoint:filemap:mm_filemap_add_to_page_cache /pid == %d && @reading[tid]/ {
+    @pagecache_miss[@fd[tid]] = count();
+}
+tracepoint:raw_syscalls:sys_enter /pid == %d/ {
+    @syscall_start[tid] = nsecs;
 }
-tracepoint:block:block_io_done /@blkdev_req[args->sector] != 0/ {
-    @blkdev_dur[args->dev] += nsecs - @blkdev_req[args->sector];
-    delete(@blkdev_req[args->sector]);
+tracepoint:raw_syscalls:sys_exit /pid == %d && @syscall_start[tid]/ {
+    @syscall_count[args->id] = count();
+    @syscall_nanos[args->id] += nsecs - @syscall_start[tid];
+    if (args->ret < 0) {@syscall_errors[args->id, -args->ret] = count();}
+    delete(@syscall_start[tid]);
 }
 interval:s:%d {
     print(@read_fd); print(@write_fd);
     print(@tcp_src); print(@tcp_dest);
-    print(@blkdev_dur); print(@blkdev_sector_count);
+    print(@syscall_count); print(@syscall_nanos); print(@syscall_errors);
+    print(@pagecache_miss); print(@fd_latency_hist);
     clear(@read_fd); clear(@write_fd);
     clear(@tcp_src); clear(@tcp_dest);
-    clear(@blkdev_dur); clear(@blkdev_sector_count);
+    clear(@syscall_count); clear(@syscall_nanos); clear(@syscall_errors);
+    clear(@pagecache_miss); clear(@fd_latency_hist);%s
 }
-	`, bpf.PID, bpf.PID, bpf.PID, bpf.PID, bpf.PID, bpf.PID, bpf.SamplingIntervalSec)
+	`, bpf.PID, bpf.fdFilterPredicate(), bpf.PID, bpf.PID, bpf.fdFilterPredicate(), bpf.PID, bpf.PID, bpf.tcpFilterPredicate(), bpf.PID, bpf.PID, bpf.PID,
+		bpf.SamplingIntervalSec, bpf.intervalStatements())
+	code += bpf.pathFilterProbes()
+	code += bpf.blockIOProbes()
+	code += bpf.syscallEventProbes()
+	code += bpf.lifecycleProbes()
+	code += bpf.lockProbes()
+	code += bpf.socketPressureProbes()
+	code += bpf.acceptProbes()
+	code += bpf.tlsProbes()
+	code += bpf.httpProbes()
 	cmd := exec.Command("bpftrace", "-e", code, "-f", "json")
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -197,12 +390,55 @@ interval:s:%d {
 	return cmd.Wait()
 }
 
+// intervalStatements prints and clears the maps of the optional and more elaborate probes at each sampling interval.
+func (bpf *BpfTracer) intervalStatements() string {
+	return bpf.blockIOIntervalStatements() + bpf.lockIntervalStatements() + socketPressureIntervalStatements + acceptIntervalStatements +
+		bpf.tlsIntervalStatements()
+}
+
 func (bpf *BpfTracer) unmarshalBpfRecord(line string) {
+	var printfRec BpfPrintfRecord
+	if err := json.Unmarshal([]byte(line), &printfRec); err == nil && printfRec.Type == "printf" {
+		if strings.HasPrefix(printfRec.Data, SyscallEventTag) {
+			bpf.handleSyscallEvent(printfRec.Data)
+		} else if strings.HasPrefix(printfRec.Data, LifecycleEventTag) {
+			bpf.handleLifecycleEvent(printfRec.Data)
+		} else if strings.HasPrefix(printfRec.Data, TLSEventTag) {
+			bpf.handleTLSEvent(printfRec.Data)
+		} else if strings.HasPrefix(printfRec.Data, HTTPSampleTag) {
+			bpf.handleHTTPSample(printfRec.Data)
+		}
+		return
+	}
+	var histRec BpfHistRecord
+	if err := json.Unmarshal([]byte(line), &histRec); err == nil && histRec.Type == "hist" && histRec.Data != nil {
+		if hist := histRec.Data["@blk_service_hist"]; hist != nil {
+			bpf.mutex.Lock()
+			bpf.BlockIOServiceHist = hist
+			bpf.BlockIOServiceHistTS = time.Now()
+			bpf.mutex.Unlock()
+			bpf.observeBlockIOServiceHist(hist)
+		} else if hist := histRec.Data["@fd_latency_hist"]; hist != nil {
+			bpf.mutex.Lock()
+			bpf.FDLatencyHist = hist
+			bpf.FDLatencyHistTS = time.Now()
+			bpf.mutex.Unlock()
+			bpf.observeFDLatencyHist(hist)
+		} else if hist := histRec.Data["@accept_first_byte_hist"]; hist != nil {
+			bpf.mutex.Lock()
+			bpf.AcceptFirstByteHist = hist
+			bpf.AcceptFirstByteHistTS = time.Now()
+			bpf.mutex.Unlock()
+			bpf.observeAcceptFirstByteHist(hist)
+		}
+		return
+	}
 	var rec BpfMapRecord
 	if err := json.Unmarshal([]byte(line), &rec); err != nil {
 		return
 	}
 	if rec.Type == "map" && rec.Data != nil {
+		bpf.countBpfMap(rec.Data)
 		if read := rec.Data["@read_fd"]; read != nil {
 			bpf.mutex.Lock()
 			bpf.FDBytesRead = read
@@ -223,23 +459,249 @@ func (bpf *BpfTracer) unmarshalBpfRecord(line string) {
 			bpf.TcpTrafficDestinations = TcpTrafficFromBpfMap(tcpDest, true)
 			bpf.TcpTrafficDestinationsTS = time.Now()
 			bpf.mutex.Unlock()
-		} else if duration := rec.Data["@blkdev_dur"]; duration != nil {
+		} else if sendBuf := rec.Data["@sk_sndbuf"]; sendBuf != nil {
+			bpf.mutex.Lock()
+			bpf.SocketSendBuf = sendBuf
+			bpf.SocketSendBufTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if recvBuf := rec.Data["@sk_rcvbuf"]; recvBuf != nil {
+			bpf.mutex.Lock()
+			bpf.SocketRecvBuf = recvBuf
+			bpf.SocketRecvBufTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if drops := rec.Data["@skb_drops"]; drops != nil {
+			bpf.mutex.Lock()
+			bpf.SkbDrops = drops
+			bpf.SkbDropsTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if overflow := rec.Data["@listen_overflow"]; overflow != nil {
+			bpf.mutex.Lock()
+			bpf.ListenOverflow = overflow
+			bpf.ListenOverflowTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if accepts := rec.Data["@accepts"]; accepts != nil {
+			bpf.mutex.Lock()
+			bpf.Accepts = accepts
+			bpf.AcceptsTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if read := rec.Data["@tls_read_bytes"]; read != nil {
+			bpf.mutex.Lock()
+			bpf.TLSReadBytes = read
+			bpf.TLSReadBytesTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if written := rec.Data["@tls_write_bytes"]; written != nil {
+			bpf.mutex.Lock()
+			bpf.TLSWriteBytes = written
+			bpf.TLSWriteBytesTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if backlog := rec.Data["@listen_max_backlog"]; backlog != nil {
+			bpf.mutex.Lock()
+			bpf.ListenMaxBacklog = backlog
+			bpf.ListenMaxBacklogTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if sectors := rec.Data["@blk_sectors"]; sectors != nil {
+			bpf.mutex.Lock()
+			bpf.BlockIOSectors = sectors
+			bpf.BlockIOSectorsTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if ops := rec.Data["@blk_ops"]; ops != nil {
+			bpf.mutex.Lock()
+			bpf.BlockIOOps = ops
+			bpf.BlockIOOpsTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if nanos := rec.Data["@blk_queue_nanos"]; nanos != nil {
+			bpf.mutex.Lock()
+			bpf.BlockIOQueueNanos = nanos
+			bpf.BlockIOQueueNanosTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if nanos := rec.Data["@blk_service_nanos"]; nanos != nil {
+			bpf.mutex.Lock()
+			bpf.BlockIOServiceNanos = nanos
+			bpf.BlockIOServiceNanosTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if nanos := rec.Data["@blk_file_nanos"]; nanos != nil {
+			bpf.mutex.Lock()
+			bpf.BlockIOFileNanos = nanos
+			bpf.BlockIOFileNanosTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if sectors := rec.Data["@blk_file_sectors"]; sectors != nil {
+			bpf.mutex.Lock()
+			bpf.BlockIOFileSectors = sectors
+			bpf.BlockIOFileSectorsTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if nanos := rec.Data["@blk_wb_file_nanos"]; nanos != nil {
+			bpf.mutex.Lock()
+			bpf.WritebackFileNanos = nanos
+			bpf.WritebackFileNanosTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if sectors := rec.Data["@blk_wb_file_sectors"]; sectors != nil {
+			bpf.mutex.Lock()
+			bpf.WritebackFileSectors = sectors
+			bpf.WritebackFileSectorsTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if pages := rec.Data["@dirtied_pages"]; pages != nil {
+			bpf.mutex.Lock()
+			bpf.DirtiedPages = pages
+			bpf.DirtiedPagesTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if millis := rec.Data["@dirty_throttle_millis"]; millis != nil {
+			bpf.mutex.Lock()
+			bpf.DirtyThrottleMillis = millis
+			bpf.DirtyThrottleMillisTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if count := rec.Data["@syscall_count"]; count != nil {
+			bpf.mutex.Lock()
+			bpf.SyscallCount = count
+			bpf.SyscallCountTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if nanos := rec.Data["@syscall_nanos"]; nanos != nil {
+			bpf.mutex.Lock()
+			bpf.SyscallNanos = nanos
+			bpf.SyscallNanosTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if errors := rec.Data["@syscall_errors"]; errors != nil {
+			bpf.mutex.Lock()
+			bpf.SyscallErrors = errors
+			bpf.SyscallErrorsTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if misses := rec.Data["@pagecache_miss"]; misses != nil {
 			bpf.mutex.Lock()
-			bpf.BlockDeviceIONanos = duration
-			bpf.BlockDeviceIONanosTS = time.Now()
+			bpf.PageCacheMisses = misses
+			bpf.PageCacheMissesTS = time.Now()
 			bpf.mutex.Unlock()
-		} else if sectors := rec.Data["@blkdev_sector_count"]; sectors != nil {
+		} else if nanos := rec.Data["@futex_wait_nanos"]; nanos != nil {
 			bpf.mutex.Lock()
-			bpf.BlockDeviceIOSectors = sectors
-			bpf.BlockDeviceIOSectorsTS = time.Now()
+			bpf.FutexWaitNanos = nanos
+			bpf.FutexWaitNanosTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if count := rec.Data["@futex_wait_count"]; count != nil {
+			bpf.mutex.Lock()
+			bpf.FutexWaitCount = count
+			bpf.FutexWaitCountTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if maxWait := rec.Data["@futex_max_wait"]; maxWait != nil {
+			bpf.mutex.Lock()
+			bpf.FutexMaxWait = maxWait
+			bpf.FutexMaxWaitTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if waiters := rec.Data["@futex_waiters"]; waiters != nil {
+			bpf.mutex.Lock()
+			bpf.FutexWaiters = waiters
+			bpf.FutexWaitersTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if stacks := rec.Data["@futex_stack_nanos"]; stacks != nil {
+			bpf.mutex.Lock()
+			bpf.FutexStackNanos = stacks
+			bpf.FutexStackNanosTS = time.Now()
+			bpf.mutex.Unlock()
+		} else if contended := rec.Data["@gomutex_contended"]; contended != nil {
+			bpf.mutex.Lock()
+			bpf.GoMutexContended = contended
+			bpf.GoMutexContendedTS = time.Now()
 			bpf.mutex.Unlock()
 		}
 	}
 }
 
+func sumBpfMap(values map[string]int) float64 {
+	var sum int
+	for _, value := range values {
+		sum += value
+	}
+	return float64(sum)
+}
+
+// countBpfMap adds the values of the maps printed at the end of a sampling interval to the Prometheus counters.
+func (bpf *BpfTracer) countBpfMap(data map[string]map[string]int) {
+	if bpf.Metrics == nil {
+		return
+	}
+	labels := prometheus.Labels{}
+	withLabel := func(name, value string) prometheus.Labels {
+		return withLabels(labels, prometheus.Labels{name: value})
+	}
+	syscallLabels := func(idStr string) prometheus.Labels {
+		number, _ := strconv.Atoi(idStr)
+		return withLabel(SyscallLabel, SyscallName(number))
+	}
+	for name, values := range data {
+		switch name {
+		case "@read_fd":
+			bpf.Metrics.FDReadBytes.With(labels).Add(sumBpfMap(values))
+			bpf.countFDSeries(labels, values, bpf.Metrics.FileReadBytes, bpf.Metrics.SocketReadBytes)
+		case "@write_fd":
+			bpf.Metrics.FDWrittenBytes.With(labels).Add(sumBpfMap(values))
+			bpf.countFDSeries(labels, values, bpf.Metrics.FileWrittenBytes, bpf.Metrics.SocketWrittenBytes)
+		case "@tcp_src":
+			bpf.Metrics.TcpSourceTrafficBytes.With(labels).Add(sumBpfMap(values))
+		case "@tcp_dest":
+			bpf.Metrics.TcpDestinationTrafficBytes.With(labels).Add(sumBpfMap(values))
+		case "@skb_drops":
+			for reason, count := range values {
+				bpf.Metrics.SkbDrops.With(withLabel(ReasonLabel, bpf.skbDropReasonName(reason))).Add(float64(count))
+			}
+		case "@listen_overflow":
+			var overflows int
+			for _, count := range targetListenOverflows(values, ReadSockets(bpf.PID)) {
+				overflows += count
+			}
+			bpf.Metrics.ListenOverflows.With(labels).Add(float64(overflows))
+		case "@tls_read_bytes":
+			bpf.Metrics.TLSReadBytes.With(labels).Add(sumBpfMap(values))
+		case "@tls_write_bytes":
+			bpf.Metrics.TLSWrittenBytes.With(labels).Add(sumBpfMap(values))
+		case "@blk_sectors":
+			bpf.Metrics.BlockIOBytes.With(labels).Add(sumBpfMap(values) * 512)
+			bpf.countDeviceSeries(labels, values, 512, bpf.Metrics.BlockDeviceIOBytes)
+		case "@blk_ops":
+			bpf.Metrics.BlockIOOps.With(labels).Add(sumBpfMap(values))
+			bpf.countDeviceSeries(labels, values, 1, bpf.Metrics.BlockDeviceIOOps)
+		case "@blk_queue_nanos":
+			bpf.Metrics.BlockIOQueueSeconds.With(labels).Add(sumBpfMap(values) / 1e9)
+		case "@dirtied_pages":
+			bpf.Metrics.DirtiedBytes.With(labels).Add(sumBpfMap(values) * float64(os.Getpagesize()))
+		case "@blk_wb_file_sectors":
+			bpf.Metrics.WrittenBackBytes.With(labels).Add(sumBpfMap(values) * 512)
+		case "@dirty_throttle_millis":
+			bpf.Metrics.DirtyThrottleSeconds.With(labels).Add(sumBpfMap(values) / 1e3)
+		case "@syscall_count":
+			for id, count := range values {
+				bpf.Metrics.Syscalls.With(syscallLabels(id)).Add(float64(count))
+			}
+		case "@syscall_nanos":
+			for id, nanos := range values {
+				bpf.Metrics.SyscallSeconds.With(syscallLabels(id)).Add(float64(nanos) / 1e9)
+			}
+		case "@syscall_errors":
+			for idErrno, count := range values {
+				id, _, _ := strings.Cut(idErrno, ",")
+				bpf.Metrics.SyscallErrors.With(syscallLabels(id)).Add(float64(count))
+			}
+		case "@futex_wait_count":
+			bpf.Metrics.FutexWaits.With(labels).Add(sumBpfMap(values))
+		case "@futex_wait_nanos":
+			bpf.Metrics.FutexWaitSeconds.With(labels).Add(sumBpfMap(values) / 1e9)
+		case "@gomutex_contended":
+			bpf.Metrics.GoMutexContentions.With(labels).Add(sumBpfMap(values))
+		}
+	}
+}
+
 type FileIOCounter struct {
 	Name                    string
 	ReadBytes, WrittenBytes int
+	FileID                  FileID
+	// CacheMissPages is the number of pages the target brought into the page cache while reading the file.
+	CacheMissPages int
+}
+
+// CacheHitRatio estimates the portion of bytes read that were served by the page cache. It returns -1 if nothing was read.
+func (counter *FileIOCounter) CacheHitRatio() float64 {
+	pagesRead := (counter.ReadBytes + os.Getpagesize() - 1) / os.Getpagesize()
+	if pagesRead == 0 {
+		return -1
+	}
+	return 1 - float64(min(counter.CacheMissPages, pagesRead))/float64(pagesRead)
 }
 
 type FileIOSummary struct {
@@ -247,7 +709,23 @@ type FileIOSummary struct {
 	ByRate []*FileIOCounter
 }
 
-func (bpf *BpfTracer) FileIOSummary(fdPaths map[int]string) *FileIOSummary {
+func (bpf *BpfTracer) observeFDLatencyHist(hist map[string][]BpfHistBucket) {
+	if bpf.Metrics == nil {
+		return
+	}
+	labels := prometheus.Labels{}
+	for _, buckets := range hist {
+		for _, bucket := range buckets {
+			if bucket.Min == nil || bucket.Max == nil {
+				continue
+			}
+			sum := float64(*bucket.Min+*bucket.Max) / 2 * float64(bucket.Count) / 1e6
+			bpf.Metrics.FDLatencySeconds.Observe(labels, float64(*bucket.Max)/1e6, uint64(bucket.Count), sum)
+		}
+	}
+}
+
+func (bpf *BpfTracer) FileIOSummary(fdPaths map[int]string, fdFileIDs map[int]FileID) *FileIOSummary {
 	ret := &FileIOSummary{
 		ByName: make(map[string]*FileIOCounter),
 		ByRate: []*FileIOCounter{},
@@ -259,7 +737,7 @@ func (bpf *BpfTracer) FileIOSummary(fdPaths map[int]string) *FileIOSummary {
 			continue
 		}
 		if _, exists := ret.ByName[fileName]; !exists {
-			ret.ByName[fileName] = &FileIOCounter{Name: fileName}
+			ret.ByName[fileName] = &FileIOCounter{Name: fileName, FileID: fdFileIDs[fdNum]}
 		}
 		ret.ByName[fileName].ReadBytes = read
 	}
@@ -270,12 +748,26 @@ func (bpf *BpfTracer) FileIOSummary(fdPaths map[int]string) *FileIOSummary {
 			continue
 		}
 		if _, exists := ret.ByName[fileName]; !exists {
-			ret.ByName[fileName] = &FileIOCounter{Name: fileName}
+			ret.ByName[fileName] = &FileIOCounter{Name: fileName, FileID: fdFileIDs[fdNum]}
 		}
 		ret.ByName[fileName].WrittenBytes = written
 	}
+	/*
+		The pages added to the page cache during a read are attributed to the FD being read, as the device of the page
+		cache inode is not the one stat reports on overlayfs and btrfs subvolumes, and the pages added by writes are not
+		misses. Sample data:
+		{"type": "map", "data": {"@pagecache_miss": {"7": 32}}}
+	*/
+	missesByName := make(map[string]int)
+	for fd, misses := range bpf.PageCacheMisses {
+		fdNum, _ := strconv.Atoi(fd)
+		if fileName, exists := fdPaths[fdNum]; exists {
+			missesByName[fileName] += misses
+		}
+	}
 
 	for _, ioCounter := range ret.ByName {
+		ioCounter.CacheMissPages = missesByName[ioCounter.Name]
 		ret.ByRate = append(ret.ByRate, ioCounter)
 	}
 	sort.Slice(ret.ByRate, func(i, j int) bool {
@@ -286,67 +778,100 @@ func (bpf *BpfTracer) FileIOSummary(fdPaths map[int]string) *FileIOSummary {
 	return ret
 }
 
-type BlockIOCounter struct {
-	DeviceName  string
-	MajorMinor  string
-	SectorCount int
-	IODuration  time.Duration
+// decodeKernelDevt splits a kernel internal dev_t, which reserves the lower 20 bits for the minor number.
+func decodeKernelDevt(devt int) (int, int) {
+	/*
+	   dev_t example:
+	   {"type": "map", "data": {"@blk_ops": {"8388608,R": 11}}}
+	*/
+	return devt >> 20, devt & 0xfffff
 }
 
-type BlockIOSummary struct {
-	ByName     map[string]*BlockIOCounter
-	ByDuration []*BlockIOCounter
+type SyscallCounter struct {
+	Number     int
+	Name       string
+	Count      int
+	Duration   time.Duration
+	ErrorCount int
+	Errors     map[string]int
 }
 
-func devtMajorMinor(devt int) (int, int) {
-	/*
-	   dev_t example:
-	   {"type": "map", "data": {"@blkdev_dur": {"8388608": 5888654}}}
-	   {"type": "map", "data": {"@blkdev_sector_count": {"8388608": 11}}}
-	*/
-	return devt >> 20, (devt >> 8) & 0x7f
+type SyscallSummary struct {
+	ByNumber   map[int]*SyscallCounter
+	ByCount    []*SyscallCounter
+	ByDuration []*SyscallCounter
 }
 
-func (bpf *BpfTracer) BlockIOSummary(diskStats map[string]blockdevice.Diskstats) *BlockIOSummary {
-	ret := &BlockIOSummary{
-		ByName:     make(map[string]*BlockIOCounter),
-		ByDuration: []*BlockIOCounter{},
+func SyscallName(number int) string {
+	if name, exists := SyscallNames[number]; exists {
+		return name
 	}
-	for devt, duration := range bpf.BlockDeviceIONanos {
-		devtNum, _ := strconv.Atoi(devt)
-		major, minor := devtMajorMinor(devtNum)
-		majorMinor := fmt.Sprintf("%d:%d", major, minor)
-		disk, exists := diskStats[majorMinor]
-		if !exists {
+	return fmt.Sprintf("syscall_%d", number)
+}
+
+func ErrnoName(errno int) string {
+	if name := unix.ErrnoName(syscall.Errno(errno)); name != "" {
+		return name
+	}
+	return fmt.Sprintf("errno_%d", errno)
+}
+
+func (bpf *BpfTracer) SyscallSummary() *SyscallSummary {
+	ret := &SyscallSummary{
+		ByNumber:   make(map[int]*SyscallCounter),
+		ByCount:    []*SyscallCounter{},
+		ByDuration: []*SyscallCounter{},
+	}
+	getCounter := func(number int) *SyscallCounter {
+		if _, exists := ret.ByNumber[number]; !exists {
+			ret.ByNumber[number] = &SyscallCounter{Number: number, Name: SyscallName(number), Errors: make(map[string]int)}
+		}
+		return ret.ByNumber[number]
+	}
+	for id, count := range bpf.SyscallCount {
+		number, err := strconv.Atoi(id)
+		if err != nil {
 			continue
 		}
-		if _, exists := ret.ByName[disk.DeviceName]; !exists {
-			ret.ByName[disk.DeviceName] = &BlockIOCounter{
-				DeviceName: disk.DeviceName,
-				MajorMinor: majorMinor,
-				IODuration: time.Duration(duration) * time.Nanosecond,
-			}
+		getCounter(number).Count = count
+	}
+	for id, nanos := range bpf.SyscallNanos {
+		number, err := strconv.Atoi(id)
+		if err != nil {
+			continue
 		}
+		getCounter(number).Duration = time.Duration(nanos) * time.Nanosecond
 	}
-	for devt, sectors := range bpf.BlockDeviceIOSectors {
-		devtNum, _ := strconv.Atoi(devt)
-		major, minor := devtMajorMinor(devtNum)
-		majorMinor := fmt.Sprintf("%d:%d", major, minor)
-		disk, exists := diskStats[majorMinor]
-		if !exists {
+	/*
+		Sample data, the key consists of syscall number and errno:
+		{"type": "map", "data": {"@syscall_errors": {"0,11": 3, "257,2": 1}}}
+	*/
+	for idErrno, count := range bpf.SyscallErrors {
+		idStr, errnoStr, found := strings.Cut(idErrno, ",")
+		if !found {
 			continue
 		}
-		if ioCounter, exists := ret.ByName[disk.DeviceName]; exists {
-			ioCounter.SectorCount = sectors
+		number, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		errno, err := strconv.Atoi(errnoStr)
+		if err != nil {
+			continue
 		}
+		counter := getCounter(number)
+		counter.Errors[ErrnoName(errno)] += count
+		counter.ErrorCount += count
 	}
-	for _, ioCounter := range ret.ByName {
-		ret.ByDuration = append(ret.ByDuration, ioCounter)
+	for _, counter := range ret.ByNumber {
+		ret.ByCount = append(ret.ByCount, counter)
+		ret.ByDuration = append(ret.ByDuration, counter)
 	}
+	sort.Slice(ret.ByCount, func(i, j int) bool {
+		return ret.ByCount[i].Count > ret.ByCount[j].Count
+	})
 	sort.Slice(ret.ByDuration, func(i, j int) bool {
-		a := ret.ByDuration[i]
-		b := ret.ByDuration[j]
-		return a.IODuration > b.IODuration
+		return ret.ByDuration[i].Duration > ret.ByDuration[j].Duration
 	})
 	return ret
 }
@@ -363,61 +888,124 @@ func (bpf *BpfTracer) Housekeeping() {
 			if time.Since(bpf.FDBytesWrittenTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
 				bpf.FDBytesWritten = make(map[string]int)
 			}
+			if time.Since(bpf.FDLatencyHistTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.FDLatencyHist = make(map[string][]BpfHistBucket)
+			}
 			if time.Since(bpf.TcpTrafficSourcesTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
 				bpf.TcpTrafficSources = make([]BpfNetIOTrafficCounter, 0)
 			}
 			if time.Since(bpf.TcpTrafficDestinationsTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
 				bpf.TcpTrafficDestinations = make([]BpfNetIOTrafficCounter, 0)
 			}
-			if time.Since(bpf.BlockDeviceIONanosTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
-				bpf.BlockDeviceIONanos = make(map[string]int)
+			if time.Since(bpf.SocketSendBufTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.SocketSendBuf = make(map[string]int)
 			}
-			if time.Since(bpf.BlockDeviceIOSectorsTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
-				bpf.BlockDeviceIOSectors = make(map[string]int)
+			if time.Since(bpf.SocketRecvBufTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.SocketRecvBuf = make(map[string]int)
 			}
-
-			hostname, _ := os.Hostname()
-			labels := prometheus.Labels{PidLabel: strconv.Itoa(bpf.PID), HostnameLabel: hostname}
-
-			sum := 0
-			for _, count := range bpf.FDBytesRead {
-				sum += count
+			if time.Since(bpf.SkbDropsTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.SkbDrops = make(map[string]int)
 			}
-			bpf.Metrics.ReadFromFDBytes.With(labels).Set(float64(sum) / float64(bpf.SamplingIntervalSec))
-			bpf.Metrics.ReadFromFDCount.With(labels).Set(float64(len(bpf.FDBytesRead)) / float64(bpf.SamplingIntervalSec))
-
-			sum = 0
-			for _, count := range bpf.FDBytesWritten {
-				sum += count
+			if time.Since(bpf.ListenOverflowTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.ListenOverflow = make(map[string]int)
 			}
-			bpf.Metrics.WrittenToFDBytes.With(labels).Set(float64(sum) / float64(bpf.SamplingIntervalSec))
-			bpf.Metrics.WrittenToFDCount.With(labels).Set(float64(len(bpf.FDBytesWritten)) / float64(bpf.SamplingIntervalSec))
-
-			sum = 0
-			for _, count := range bpf.TcpTrafficSources {
-				sum += count.ByteCounter
+			if time.Since(bpf.AcceptsTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.Accepts = make(map[string]int)
 			}
-			bpf.Metrics.TcpSourceTrafficBytes.With(labels).Set(float64(sum) / float64(bpf.SamplingIntervalSec))
-			bpf.Metrics.TcpSourceEndpointsCount.With(labels).Set(float64(len(bpf.TcpTrafficSources)) / float64(bpf.SamplingIntervalSec))
-
-			sum = 0
-			for _, count := range bpf.TcpTrafficDestinations {
-				sum += count.ByteCounter
+			if time.Since(bpf.AcceptFirstByteHistTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.AcceptFirstByteHist = make(map[string][]BpfHistBucket)
 			}
-			bpf.Metrics.TcpDestinationTrafficBytes.With(labels).Set(float64(sum) / float64(bpf.SamplingIntervalSec))
-			bpf.Metrics.TcpDestinationEndpointsCount.With(labels).Set(float64(len(bpf.TcpTrafficDestinations)) / float64(bpf.SamplingIntervalSec))
-
-			sum = 0
-			for _, count := range bpf.BlockDeviceIOSectors {
-				sum += count
+			if time.Since(bpf.ListenMaxBacklogTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.ListenMaxBacklog = make(map[string]int)
+			}
+			if bpf.HTTPEnabled {
+				bpf.rotateHTTPWindow()
+			}
+			if time.Since(bpf.TLSReadBytesTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.TLSReadBytes = make(map[string]int)
+			}
+			if time.Since(bpf.TLSWriteBytesTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.TLSWriteBytes = make(map[string]int)
+			}
+			if time.Since(bpf.BlockIOSectorsTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.BlockIOSectors = make(map[string]int)
+			}
+			if time.Since(bpf.BlockIOOpsTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.BlockIOOps = make(map[string]int)
+			}
+			if time.Since(bpf.BlockIOQueueNanosTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.BlockIOQueueNanos = make(map[string]int)
+			}
+			if time.Since(bpf.BlockIOServiceNanosTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.BlockIOServiceNanos = make(map[string]int)
+			}
+			if time.Since(bpf.BlockIOServiceHistTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.BlockIOServiceHist = make(map[string][]BpfHistBucket)
+			}
+			if time.Since(bpf.BlockIOFileNanosTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.BlockIOFileNanos = make(map[string]int)
+			}
+			if time.Since(bpf.BlockIOFileSectorsTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.BlockIOFileSectors = make(map[string]int)
+			}
+			if time.Since(bpf.WritebackFileNanosTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.WritebackFileNanos = make(map[string]int)
+			}
+			if time.Since(bpf.WritebackFileSectorsTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.WritebackFileSectors = make(map[string]int)
+			}
+			if time.Since(bpf.DirtiedPagesTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.DirtiedPages = make(map[string]int)
+			}
+			if time.Since(bpf.DirtyThrottleMillisTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.DirtyThrottleMillis = make(map[string]int)
+			}
+			if time.Since(bpf.SyscallCountTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.SyscallCount = make(map[string]int)
+			}
+			if time.Since(bpf.SyscallNanosTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.SyscallNanos = make(map[string]int)
+			}
+			if time.Since(bpf.SyscallErrorsTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.SyscallErrors = make(map[string]int)
+			}
+			if time.Since(bpf.PageCacheMissesTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.PageCacheMisses = make(map[string]int)
+			}
+			if time.Since(bpf.FutexWaitNanosTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.FutexWaitNanos = make(map[string]int)
+			}
+			if time.Since(bpf.FutexWaitCountTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.FutexWaitCount = make(map[string]int)
+			}
+			if time.Since(bpf.FutexMaxWaitTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.FutexMaxWait = make(map[string]int)
+			}
+			if time.Since(bpf.FutexWaitersTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.FutexWaiters = make(map[string]int)
+			}
+			if time.Since(bpf.FutexStackNanosTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.FutexStackNanos = make(map[string]int)
+			}
+			if time.Since(bpf.GoMutexContendedTS) > time.Duration(bpf.SamplingIntervalSec)*time.Second {
+				bpf.GoMutexContended = make(map[string]int)
 			}
-			bpf.Metrics.BlockIOSectors.With(labels).Set(float64(sum) / float64(bpf.SamplingIntervalSec))
 
-			sum = 0
-			for _, count := range bpf.BlockDeviceIONanos {
-				sum += count
+			// The counters are added to as the maps arrive, only the point-in-time values are set here.
+			labels := prometheus.Labels{}
+			bpf.Metrics.ReadFDs.With(labels).Set(float64(len(bpf.FDBytesRead)))
+			bpf.Metrics.WrittenFDs.With(labels).Set(float64(len(bpf.FDBytesWritten)))
+			bpf.Metrics.TcpSourceEndpoints.With(labels).Set(float64(len(bpf.TcpTrafficSources)))
+			bpf.Metrics.TcpDestinationEndpoints.With(labels).Set(float64(len(bpf.TcpTrafficDestinations)))
+
+			pressure := bpf.SocketPressureSummary(ReadSockets(bpf.PID))
+			var sendQueue, recvQueue int
+			for _, sock := range pressure.ByQueueDepth {
+				sendQueue += sock.Socket.TxQueue
+				recvQueue += sock.Socket.RxQueue
 			}
-			bpf.Metrics.BlockIOTimeMillis.With(labels).Set(float64(sum/1000000) / float64(bpf.SamplingIntervalSec))
+			bpf.Metrics.SocketSendQueueBytes.With(labels).Set(float64(sendQueue))
+			bpf.Metrics.SocketRecvQueueBytes.With(labels).Set(float64(recvQueue))
 			bpf.mutex.Unlock()
 		case <-bpf.stop:
 			return
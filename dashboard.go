@@ -0,0 +1,19 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var dashboardAssets embed.FS
+
+// registerDashboard serves the web dashboard, which renders the API summary and event stream in the browser.
+func (api *APIServer) registerDashboard(mux *http.ServeMux) {
+	assets, err := fs.Sub(dashboardAssets, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("/", http.FileServer(http.FS(assets)))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type EventModel struct {
+	PID    int
+	BPF    *BpfTracer
+	Proc   *ProcInfo
+	Width  int
+	Height int
+}
+
+func NewEventModel(pid int, procInfo *ProcInfo, bpf *BpfTracer) *EventModel {
+	return &EventModel{PID: pid, Proc: procInfo, BPF: bpf}
+}
+
+func (model *EventModel) Init() tea.Cmd {
+	return nil
+}
+
+func (model *EventModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
+	}
+	return model, nil
+}
+
+func (model *EventModel) GetRegularStyle() lipgloss.Style {
+	return lipgloss.NewStyle().
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
+		BorderStyle(lipgloss.RoundedBorder())
+}
+
+func (model *EventModel) GetFocusedStyle() lipgloss.Style {
+	return lipgloss.NewStyle().Inherit(model.GetRegularStyle()).
+		BorderForeground(lipgloss.Color(FocusedBorderForeground)).
+		BorderBackground(lipgloss.Color(FocusedBorderBackground))
+}
+
+func (model *EventModel) View() string {
+	var ret string
+	ret += genericLabel.Render("Syscall events") + "\n"
+	if len(model.BPF.EventSyscalls) == 0 && model.BPF.TLSCaptureBytes == 0 {
+		ret += "Start procshave with -events=openat,connect to stream syscall events."
+		return ret
+	}
+	type eventLine struct {
+		time time.Time
+		line string
+	}
+	var lines []eventLine
+	for _, evt := range model.BPF.LatestSyscallEvents(model.Height - 3) {
+		lines = append(lines, eventLine{evt.Time, evt.Format(model.Proc.TargetInfo.FDPath)})
+	}
+	for _, evt := range model.BPF.LatestTLSEvents(model.Height - 3) {
+		lines = append(lines, eventLine{evt.Time, evt.Format(model.Proc.TargetInfo.FDPath)})
+	}
+	if len(lines) == 0 {
+		ret += "No data yet."
+		return ret
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].time.Before(lines[j].time)
+	})
+	for _, line := range lines[max(0, len(lines)-model.Height+3):] {
+		ret += PathCaption(line.line, max(20, model.Width-4)) + "\n"
+	}
+	return ret
+}
@@ -2,21 +2,47 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
 )
 
+// openFlagNames are the file status flags shown in the detail view, O_SYNC comes before O_DSYNC as it includes its bit.
+var openFlagNames = []struct {
+	Flag int
+	Name string
+}{
+	{unix.O_APPEND, "O_APPEND"}, {unix.O_NONBLOCK, "O_NONBLOCK"}, {unix.O_SYNC, "O_SYNC"}, {unix.O_DSYNC, "O_DSYNC"},
+	{unix.O_DIRECT, "O_DIRECT"}, {unix.O_NOATIME, "O_NOATIME"}, {unix.O_PATH, "O_PATH"}, {unix.O_CLOEXEC, "O_CLOEXEC"},
+}
+
 type FileModel struct {
-	PID       int
-	BPF       *BpfTracer
-	Proc      *ProcInfo
-	TermWidth int
+	PID     int
+	BPF     *BpfTracer
+	Proc    *ProcInfo
+	History *History
+	Width   int
+	Height  int
+	Cursor  RowCursor
+	Sort    SortOrder
+	Filter  RowFilter
 }
 
-func NewFileModel(pid int, procInfo *ProcInfo, bpf *BpfTracer) *FileModel {
-	return &FileModel{PID: pid, Proc: procInfo, BPF: bpf}
+const (
+	FileSortTotal   = "total"
+	FileSortRead    = "read"
+	FileSortWritten = "written"
+)
+
+func NewFileModel(pid int, procInfo *ProcInfo, bpf *BpfTracer, history *History) *FileModel {
+	return &FileModel{PID: pid, Proc: procInfo, BPF: bpf, History: history, Sort: NewSortOrder(FileSortTotal, FileSortRead, FileSortWritten)}
 }
 
 func (model *FileModel) Init() tea.Cmd {
@@ -26,19 +52,17 @@ func (model *FileModel) Init() tea.Cmd {
 func (model *FileModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.String() {
-		case tea.KeyEnter.String():
-			log.Printf("@@@@@ got enter")
-		}
-	case tea.WindowSizeMsg:
-		model.TermWidth = msg.Width
+		model.Cursor.Update(msg)
+		model.Sort.Update(msg)
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
 	}
 	return model, nil
 }
 
 func (model *FileModel) GetRegularStyle() lipgloss.Style {
 	return lipgloss.NewStyle().
-		Width(model.TermWidth/2-2).Height(15).Align(lipgloss.Left, lipgloss.Top).
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
 		BorderStyle(lipgloss.RoundedBorder())
 }
 
@@ -48,22 +72,136 @@ func (model *FileModel) GetFocusedStyle() lipgloss.Style {
 		BorderBackground(lipgloss.Color(FocusedBorderBackground))
 }
 
+func (model *FileModel) GetFilter() *RowFilter {
+	return &model.Filter
+}
+
 func (model *FileModel) View() string {
-	var ret string
-	ret += genericLabel.Render("File IO activities") + "\n"
-	files := model.BPF.FileIOSummary(model.Proc.TargetInfo.FDPath)
-	if len(files.ByRate) == 0 {
+	files := model.sortedFiles()
+	var rowNames []string
+	for _, file := range files {
+		rowNames = append(rowNames, file.Name)
+	}
+	from, to := model.Cursor.SetRows(rowNames, model.Height-3)
+	ret := ListHeader("File IO activities", model.Sort, model.Cursor, model.Filter) + "\n"
+	if len(files) == 0 {
 		ret += "No data yet."
 		return ret
 	}
-	for i, file := range files.ByRate {
-		if i == 12 {
-			break
-		}
-		ret += fmt.Sprintf("%-27s R %-8s W %-8s\n",
+	for i := from; i < to; i++ {
+		file := files[i]
+		ret += model.Cursor.Render(i, fmt.Sprintf("%-27s %s R %-8s W %-8s %s",
 			PathCaption(file.Name, 25),
+			model.History.Sparkline(HistoryKey(HistoryFile, file.Name), SparklineWidth(model.Width, 64)),
 			IORateCaption(file.ReadBytes/model.BPF.SamplingIntervalSec),
-			IORateCaption(file.WrittenBytes/model.BPF.SamplingIntervalSec))
+			IORateCaption(file.WrittenBytes/model.BPF.SamplingIntervalSec),
+			model.cacheCaption(file))) + "\n"
 	}
 	return ret
 }
+
+func (model *FileModel) sortedFiles() []*FileIOCounter {
+	files := model.BPF.FileIOSummary(model.Proc.TargetInfo.FDPath, model.Proc.TargetInfo.FDFileID).ByRate
+	switch model.Sort.Column() {
+	case FileSortRead:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].ReadBytes > files[j].ReadBytes
+		})
+	case FileSortWritten:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].WrittenBytes > files[j].WrittenBytes
+		})
+	}
+	return slices.DeleteFunc(files, func(file *FileIOCounter) bool {
+		return !model.Filter.Match(file.Name, nil)
+	})
+}
+
+func (model *FileModel) OpenDetail() bool {
+	return model.Cursor.OpenDetail()
+}
+
+// DetailView shows the IO rates of the selected file, and the flags, position, age, and read and write latency of each
+// FD that has the file open.
+func (model *FileModel) DetailView(width, height int) string {
+	name := model.Cursor.Detail
+	target := model.Proc.TargetInfo
+	ret := genericLabel.Render("File "+name) + "\n"
+	if file, exists := model.BPF.FileIOSummary(target.FDPath, target.FDFileID).ByName[name]; exists {
+		ret += fmt.Sprintf("Read %s, written %s %s\n",
+			IORateCaption(file.ReadBytes/model.BPF.SamplingIntervalSec), IORateCaption(file.WrittenBytes/model.BPF.SamplingIntervalSec), model.cacheCaption(file))
+	} else {
+		ret += "No IO in the latest interval.\n"
+	}
+	ret += "\n" + genericLabel.Render("File descriptors") + "\n"
+	var fds []int
+	for fd, path := range target.FDPath {
+		if path == name {
+			fds = append(fds, fd)
+		}
+	}
+	sort.Ints(fds)
+	if len(fds) == 0 {
+		ret += "The file is no longer open.\n"
+	}
+	proc, _ := procfs.NewProc(model.PID)
+	for _, fd := range fds {
+		var flags, pos string
+		if info, err := proc.FDInfo(strconv.Itoa(fd)); err == nil {
+			flags, pos = OpenFlagsCaption(info.Flags), info.Pos
+		}
+		ret += fmt.Sprintf("fd %-5d %-34s pos %-12s open for at least %-10v %s\n",
+			fd, flags, pos, time.Since(target.FDSince[fd]).Round(time.Second), HistogramCaption(model.BPF.FDLatencyHist[strconv.Itoa(fd)]))
+	}
+	ret += "\n"
+	chartHeight := height - strings.Count(ret, "\n") - 1
+	return ret + model.History.RenderChart([]ChartSeries{
+		{Title: "Bytes read and written", Key: HistoryKey(HistoryFile, name), Caption: RateCaption},
+	}, width, chartHeight)
+}
+
+// OpenFlagsCaption names the access mode and status flags of an FD from the octal flags of its fdinfo.
+func OpenFlagsCaption(octalFlags string) string {
+	flags, err := strconv.ParseInt(octalFlags, 8, 64)
+	if err != nil {
+		return octalFlags
+	}
+	var names []string
+	switch int(flags) & unix.O_ACCMODE {
+	case unix.O_RDONLY:
+		names = append(names, "O_RDONLY")
+	case unix.O_WRONLY:
+		names = append(names, "O_WRONLY")
+	case unix.O_RDWR:
+		names = append(names, "O_RDWR")
+	}
+	for _, flag := range openFlagNames {
+		if int(flags)&flag.Flag == flag.Flag {
+			names = append(names, flag.Name)
+			flags &^= int64(flag.Flag)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+func (model *FileModel) cacheCaption(file *FileIOCounter) string {
+	ratio := file.CacheHitRatio()
+	if ratio < 0 {
+		return ""
+	}
+	ret := fmt.Sprintf("hit %d%%", int(ratio*100))
+	if file.CacheMissPages > 0 {
+		// Name the block device that served the cache misses, so that they can be correlated with the block device panel.
+		if disk, exists := model.Proc.DiskStats[file.FileID.MajorMinor()]; exists {
+			ret += " " + disk.DeviceName
+		}
+	}
+	return ret
+}
+
+func (model *FileModel) ChartSeries() []ChartSeries {
+	return []ChartSeries{
+		{Title: "File bytes read", Key: HistoryKey(HistoryTotal, TotalFileRead), Caption: RateCaption},
+		{Title: "File bytes written", Key: HistoryKey(HistoryTotal, TotalFileWritten), Caption: RateCaption},
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// RowFilter narrows a list panel down to the rows matching all of its space separated terms. A term matches a row by
+// substring, by regular expression if it starts with "~", or by containing the IP address of the row if it is an IP
+// address or CIDR network. A term starting with "!" excludes the matching rows instead.
+type RowFilter struct {
+	Text  string
+	terms []filterTerm
+}
+
+type filterTerm struct {
+	exclude   bool
+	substring string
+	regex     *regexp.Regexp
+	network   *net.IPNet
+}
+
+func ParseRowFilter(text string) (RowFilter, error) {
+	ret := RowFilter{Text: strings.Join(strings.Fields(text), " ")}
+	for _, field := range strings.Fields(text) {
+		var term filterTerm
+		term.exclude = strings.HasPrefix(field, "!")
+		field = strings.TrimPrefix(field, "!")
+		if pattern, isRegex := strings.CutPrefix(field, "~"); isRegex {
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				return RowFilter{}, fmt.Errorf("bad regular expression %q: %w", pattern, err)
+			}
+			term.regex = regex
+		} else {
+			term.substring = field
+			// A path may look like a network too, the rows without an IP address are matched by substring.
+			term.network, _ = ParseNetwork(field)
+		}
+		if field == "" {
+			return RowFilter{}, fmt.Errorf("empty filter term")
+		}
+		ret.terms = append(ret.terms, term)
+	}
+	return ret, nil
+}
+
+// Match tells whether a row passes the filter, ip is nil for the rows that are not network endpoints.
+func (filter RowFilter) Match(row string, ip net.IP) bool {
+	for _, term := range filter.terms {
+		var matched bool
+		switch {
+		case term.regex != nil:
+			matched = term.regex.MatchString(row)
+		case term.network != nil && ip != nil:
+			matched = term.network.Contains(ip)
+		default:
+			matched = strings.Contains(row, term.substring)
+		}
+		if matched == term.exclude {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HistoryFile           = "file"
+	HistoryTCPIn          = "tcp_in"
+	HistoryTCPOut         = "tcp_out"
+	HistoryBlkdev         = "blkdev"
+	HistorySyscall        = "syscall"
+	HistoryTotal          = "total"
+	TotalFileRead         = "file_read"
+	TotalFileWritten      = "file_written"
+	TotalTCPIn            = "tcp_in"
+	TotalTCPOut           = "tcp_out"
+	TotalBlkdevRead       = "blkdev_read"
+	TotalBlkdevWritten    = "blkdev_written"
+	TotalSyscalls         = "syscalls"
+	TotalSyscallErrors    = "syscall_errors"
+	TotalThreadsRunning   = "threads_running"
+	TotalThreadsSleeping  = "threads_sleeping"
+	TotalThreadsOther     = "threads_other"
+	TotalResidentSetBytes = "rss"
+)
+
+// History keeps a ring buffer of the per-interval samples of each file, TCP endpoint, block device, syscall, and the
+// overall totals. All series share the same write position, so that their samples line up in time.
+type History struct {
+	mutex *sync.Mutex
+	// Capacity is the number of samples kept of each series.
+	Capacity int
+	// Samples is the number of samples taken since the start.
+	Samples int
+	series  map[string]*historySeries
+}
+
+type historySeries struct {
+	values []float64
+	// lastNonZero is the sample number of the latest non-zero value, the series is forgotten once it falls out of the
+	// ring.
+	lastNonZero int
+}
+
+func NewHistory(capacity int) *History {
+	return &History{
+		mutex:    new(sync.Mutex),
+		Capacity: max(capacity, 1),
+		series:   make(map[string]*historySeries),
+	}
+}
+
+func HistoryKey(kind, name string) string {
+	return kind + ":" + name
+}
+
+// Add appends a sample of each series, the series absent from the sample get a zero.
+func (history *History) Add(sample map[string]float64) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	pos := history.Samples % history.Capacity
+	for key, value := range sample {
+		if _, exists := history.series[key]; !exists {
+			history.series[key] = &historySeries{values: make([]float64, history.Capacity)}
+		}
+		if value != 0 {
+			history.series[key].lastNonZero = history.Samples
+		}
+	}
+	for key, series := range history.series {
+		if history.Samples-series.lastNonZero >= history.Capacity {
+			delete(history.series, key)
+			continue
+		}
+		series.values[pos] = sample[key]
+	}
+	history.Samples++
+}
+
+// Values returns the samples of a series from the oldest to the latest.
+func (history *History) Values(key string) []float64 {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+	count := min(history.Samples, history.Capacity)
+	ret := make([]float64, count)
+	series, exists := history.series[key]
+	if !exists {
+		return ret
+	}
+	for i := range ret {
+		ret[i] = series.values[(history.Samples-count+i)%history.Capacity]
+	}
+	return ret
+}
+
+// Sparkline renders the latest samples of a series, one character each, scaled to the largest of them.
+func (history *History) Sparkline(key string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	values := history.Values(key)
+	values = values[max(0, len(values)-width):]
+	var maxValue float64
+	for _, value := range values {
+		maxValue = math.Max(maxValue, value)
+	}
+	ret := strings.Repeat(" ", width-len(values))
+	for _, value := range values {
+		if value <= 0 || maxValue == 0 {
+			ret += " "
+			continue
+		}
+		ret += string(sparklineBlocks[int(value*float64(len(sparklineBlocks)-1)/maxValue)])
+	}
+	return ret
+}
+
+// Record samples the activities of the latest interval.
+func (history *History) Record(procInfo *ProcInfo, bpf *BpfTracer) {
+	interval := float64(bpf.SamplingIntervalSec)
+	sample := make(map[string]float64)
+	addTotal := func(name string, value float64) {
+		sample[HistoryKey(HistoryTotal, name)] += value
+	}
+	procInfo.Mutex.RLock()
+	defer procInfo.Mutex.RUnlock()
+	target := procInfo.TargetInfo
+	for _, stat := range target.Stat {
+		switch stat.State {
+		case "R":
+			addTotal(TotalThreadsRunning, 1)
+		case "S":
+			addTotal(TotalThreadsSleeping, 1)
+		default:
+			addTotal(TotalThreadsOther, 1)
+		}
+	}
+	addTotal(TotalResidentSetBytes, float64(target.MainStatus.VmRSS))
+
+	bpf.mutex.Lock()
+	defer bpf.mutex.Unlock()
+	for _, file := range bpf.FileIOSummary(target.FDPath, target.FDFileID).ByRate {
+		sample[HistoryKey(HistoryFile, file.Name)] = float64(file.ReadBytes+file.WrittenBytes) / interval
+		addTotal(TotalFileRead, float64(file.ReadBytes)/interval)
+		addTotal(TotalFileWritten, float64(file.WrittenBytes)/interval)
+	}
+	for _, counter := range bpf.TcpTrafficDestinations {
+		sample[HistoryKey(HistoryTCPIn, counter.Endpoint())] = float64(counter.ByteCounter) / interval
+		addTotal(TotalTCPIn, float64(counter.ByteCounter)/interval)
+	}
+	for _, counter := range bpf.TcpTrafficSources {
+		sample[HistoryKey(HistoryTCPOut, counter.Endpoint())] = float64(counter.ByteCounter) / interval
+		addTotal(TotalTCPOut, float64(counter.ByteCounter)/interval)
+	}
+	for _, blkdev := range bpf.BlockIOSummary(procInfo.DiskStats).ByDuration {
+		read, write := blkdev.Op(BlockIORead), blkdev.Op(BlockIOWrite)
+		sample[HistoryKey(HistoryBlkdev, blkdev.DeviceName)] = float64(blkdev.SectorCount*512) / interval
+		addTotal(TotalBlkdevRead, float64(read.SectorCount*512)/interval)
+		addTotal(TotalBlkdevWritten, float64(write.SectorCount*512)/interval)
+	}
+	for _, syscall := range bpf.SyscallSummary().ByCount {
+		sample[HistoryKey(HistorySyscall, syscall.Name)] = float64(syscall.Count) / interval
+		addTotal(TotalSyscalls, float64(syscall.Count)/interval)
+		addTotal(TotalSyscallErrors, float64(syscall.ErrorCount)/interval)
+	}
+	history.Add(sample)
+}
+
+// Start records the activities at each sampling interval.
+func (history *History) Start(procInfo *ProcInfo, bpf *BpfTracer) {
+	for range time.Tick(time.Duration(bpf.SamplingIntervalSec) * time.Second) {
+		history.Record(procInfo, bpf)
+	}
+}
+
+// ChartSeries is a series of the history shown in the full-screen chart of a panel.
+type ChartSeries struct {
+	Title   string
+	Key     string
+	Caption func(float64) string
+}
+
+// ChartPanel is a panel that can be charted full-screen from the history.
+type ChartPanel interface {
+	ChartSeries() []ChartSeries
+}
+
+// RenderChart draws each series as a bar chart, one column per sample, that together fill the width and height.
+func (history *History) RenderChart(seriesList []ChartSeries, width, height int) string {
+	if len(seriesList) == 0 {
+		return "No chart for this panel."
+	}
+	var ret []string
+	rows := max(2, height/len(seriesList)-1)
+	for _, series := range seriesList {
+		values := downsample(history.Values(series.Key), width)
+		var latest, maxValue float64
+		if len(values) > 0 {
+			latest = values[len(values)-1]
+		}
+		for _, value := range values {
+			maxValue = math.Max(maxValue, value)
+		}
+		ret = append(ret, genericLabel.Render(series.Title)+fmt.Sprintf(" now %s, max %s", series.Caption(latest), series.Caption(maxValue)))
+		// Each row is divided into eighths, drawn with the sparkline blocks from the bottom up.
+		eighths := make([]int, len(values))
+		for i, value := range values {
+			if maxValue > 0 {
+				eighths[i] = int(value * float64(rows*8) / maxValue)
+			}
+		}
+		for row := rows - 1; row >= 0; row-- {
+			var line []rune
+			for _, height := range eighths {
+				fill := height - row*8
+				switch {
+				case fill >= 8:
+					line = append(line, sparklineBlocks[len(sparklineBlocks)-1])
+				case fill > 0:
+					line = append(line, sparklineBlocks[fill-1])
+				default:
+					line = append(line, ' ')
+				}
+			}
+			ret = append(ret, string(line))
+		}
+	}
+	return strings.Join(ret, "\n")
+}
+
+// downsample averages the values into at most width buckets.
+func downsample(values []float64, width int) []float64 {
+	if len(values) <= width || width <= 0 {
+		return values
+	}
+	ret := make([]float64, width)
+	for i := range ret {
+		from, to := i*len(values)/width, (i+1)*len(values)/width
+		var sum float64
+		for _, value := range values[from:to] {
+			sum += value
+		}
+		ret[i] = sum / float64(max(1, to-from))
+	}
+	return ret
+}
+
+func RateCaption(bytesPerSec float64) string {
+	return IORateCaption(int(bytesPerSec))
+}
+
+func CountCaption(value float64) string {
+	return fmt.Sprintf("%.1f", value)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+const (
+	CommLabel          = "comm"
+	ExeLabel           = "exe"
+	SystemdUnitLabel   = "systemd_unit"
+	ContainerIDLabel   = "container_id"
+	ContainerNameLabel = "container_name"
+	K8sNamespaceLabel  = "k8s_namespace"
+	K8sPodLabel        = "k8s_pod"
+	K8sPodUIDLabel     = "k8s_pod_uid"
+)
+
+var (
+	// IdentityLabelNames are the labels that can identify the target process, all of them but pid stay the same across
+	// restarts of the process.
+	IdentityLabelNames = []string{PidLabel, HostnameLabel, CommLabel, ExeLabel, SystemdUnitLabel, ContainerIDLabel, ContainerNameLabel, K8sNamespaceLabel, K8sPodLabel, K8sPodUIDLabel}
+	// ContainerIDRegex matches the container ID in a cgroup path of docker, containerd, CRI-O, and podman, such as
+	// "/system.slice/docker-<id>.scope" and "/kubepods.slice/.../cri-containerd-<id>.scope".
+	ContainerIDRegex = regexp.MustCompile(`(?:^|[/-])([0-9a-f]{64})(?:\.scope)?$`)
+	// K8sPodUIDRegex matches the pod UID in a cgroup path like "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice",
+	// the dashes of the UID are replaced by underscores with the systemd cgroup driver.
+	K8sPodUIDRegex = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+	LabelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// MetricLabelNames are the labels that tell apart the series of a metric, they cannot be used as static labels.
+	MetricLabelNames = []string{SyscallLabel, SignalLabel, DeviceLabel, ReasonLabel, MethodLabel, RouteLabel, StatusLabel, PathLabel, RemoteAddrLabel, RemotePortLabel}
+)
+
+// ReadProcessIdentity returns the identity label values of the process, those that cannot be determined are left out.
+func ReadProcessIdentity(pid int, procInfo *ProcInfo) map[string]string {
+	ret := make(map[string]string)
+	ret[PidLabel] = strconv.Itoa(pid)
+	ret[HostnameLabel], _ = os.Hostname()
+	procInfo.Mutex.RLock()
+	ret[CommLabel] = procInfo.TargetInfo.MainComm
+	ret[ExeLabel] = procInfo.TargetInfo.MainExec
+	procInfo.Mutex.RUnlock()
+
+	fs, _ := procfs.NewDefaultFS()
+	proc, err := fs.Proc(pid)
+	if err != nil {
+		return ret
+	}
+	cgroups, _ := proc.Cgroups()
+	for _, cgroup := range cgroups {
+		for _, segment := range strings.Split(cgroup.Path, "/") {
+			if strings.HasSuffix(segment, ".service") {
+				ret[SystemdUnitLabel] = segment
+			}
+		}
+		if match := ContainerIDRegex.FindStringSubmatch(cgroup.Path); len(match) == 2 {
+			ret[ContainerIDLabel] = match[1]
+		}
+		if match := K8sPodUIDRegex.FindStringSubmatch(cgroup.Path); len(match) == 2 {
+			ret[K8sPodUIDLabel] = strings.ReplaceAll(match[1], "_", "-")
+		}
+	}
+	if ret[ContainerIDLabel] != "" {
+		ret[ContainerNameLabel] = dockerContainerName(ret[ContainerIDLabel])
+	}
+	if ret[K8sPodUIDLabel] != "" {
+		// A pod's hostname is its name, and the namespace comes with the service account mounted into the container.
+		environ, _ := proc.Environ()
+		for _, env := range environ {
+			if name, found := strings.CutPrefix(env, "HOSTNAME="); found {
+				ret[K8sPodLabel] = name
+			}
+		}
+		namespace, _ := os.ReadFile(fmt.Sprintf("/proc/%d/root/var/run/secrets/kubernetes.io/serviceaccount/namespace", pid))
+		ret[K8sNamespaceLabel] = strings.TrimSpace(string(namespace))
+	}
+	for name, value := range ret {
+		if value == "" {
+			delete(ret, name)
+		}
+	}
+	return ret
+}
+
+// dockerContainerName returns an empty string unless the container is run by docker on this host.
+func dockerContainerName(containerID string) string {
+	content, err := os.ReadFile(filepath.Join("/var/lib/docker/containers", containerID, "config.v2.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		Name string `json:"Name"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(config.Name, "/")
+}
+
+// IdentityLabels picks the comma separated identity labels of the process, and adds the static labels given as
+// key=value.
+func IdentityLabels(identity map[string]string, names string, static []string) (prometheus.Labels, error) {
+	ret := make(prometheus.Labels)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, identityName := range IdentityLabelNames {
+			known = known || identityName == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown identity label %q, expecting one of %s", name, strings.Join(IdentityLabelNames, ", "))
+		}
+		if value, exists := identity[name]; exists {
+			ret[name] = value
+		}
+	}
+	for _, keyValue := range static {
+		key, value, found := strings.Cut(keyValue, "=")
+		if !found || !LabelNameRegex.MatchString(key) {
+			return nil, fmt.Errorf("malformed label %q, expecting key=value", keyValue)
+		}
+		for _, name := range MetricLabelNames {
+			if key == name {
+				return nil, fmt.Errorf("label %q is already used by the metrics", key)
+			}
+		}
+		ret[key] = value
+	}
+	return ret, nil
+}
+
+// LabelFlags collects the values of a repeated command line flag.
+type LabelFlags []string
+
+func (flags *LabelFlags) String() string {
+	return strings.Join(*flags, ",")
+}
+
+func (flags *LabelFlags) Set(value string) error {
+	*flags = append(*flags, value)
+	return nil
+}
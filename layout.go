@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// PanelNames are the names of the panels in a layout file, in the order of the default layout.
+var PanelNames = []string{"overview", "file", "net", "blkdev", "syscall", "event", "lifecycle", "lock", "listen", "request"}
+
+// PanelLayout is the rows of panels from top to bottom, each row lists the names of its panels from left to right.
+type PanelLayout [][]string
+
+func DefaultPanelLayout() PanelLayout {
+	var ret PanelLayout
+	for i := 0; i < len(PanelNames); i += PanelsPerRow {
+		ret = append(ret, PanelNames[i:min(len(PanelNames), i+PanelsPerRow)])
+	}
+	return ret
+}
+
+// ReadPanelLayout reads a layout file of a row of space separated panel names per line, e.g. "overview file". The
+// panels left out of the file are not shown, and the blank lines and the lines starting with "#" are ignored.
+func ReadPanelLayout(path string) (PanelLayout, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret PanelLayout
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		row := strings.Fields(line)
+		for _, name := range row {
+			if !slices.Contains(PanelNames, name) {
+				return nil, fmt.Errorf("%s:%d: unknown panel %q, the panels are: %s", path, i+1, name, strings.Join(PanelNames, ", "))
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("%s:%d: panel %q is already in the layout", path, i+1, name)
+			}
+			seen[name] = true
+		}
+		ret = append(ret, row)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%s does not name any panel", path)
+	}
+	return ret, nil
+}
+
+// panelBox is a panel placed by the layout with its outer size, Index is the position of the panel in the focus order.
+type panelBox struct {
+	Panel
+	Index         int
+	Width, Height int
+}
+
+// arrangePanels fits the layout to the terminal. The rows too wide for the terminal wrap onto as many rows as it takes
+// for their panels to be at least MinPanelWidth wide, down to a panel per row. The rows share the height if they all
+// fit in it, otherwise they keep the least height and the view scrolls.
+func (model *MainModel) arrangePanels(height int) [][]panelBox {
+	panels := model.Panels()
+	perRow := max(1, model.TermWidth/MinPanelWidth)
+	var rows [][]panelBox
+	var index int
+	for _, names := range model.Layout {
+		for len(names) > 0 {
+			count := min(perRow, len(names))
+			var row []panelBox
+			for i := 0; i < count; i++ {
+				width := model.TermWidth / count
+				if i == count-1 {
+					width = model.TermWidth - width*(count-1)
+				}
+				row = append(row, panelBox{Panel: panels[index], Index: index, Width: width})
+				index++
+			}
+			rows = append(rows, row)
+			names = names[count:]
+		}
+	}
+	rowHeight, extra := PanelHeight, 0
+	if len(rows) > 0 && height >= len(rows)*PanelHeight {
+		rowHeight, extra = height/len(rows), height%len(rows)
+	}
+	for i, row := range rows {
+		for j := range row {
+			row[j].Height = rowHeight
+			if i < extra {
+				row[j].Height++
+			}
+		}
+	}
+	return rows
+}
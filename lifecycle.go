@@ -0,0 +1,69 @@
+package main
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	lifecycleFatalStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#cd4439"))
+)
+
+type LifecycleModel struct {
+	PID    int
+	BPF    *BpfTracer
+	Proc   *ProcInfo
+	Width  int
+	Height int
+}
+
+func NewLifecycleModel(pid int, procInfo *ProcInfo, bpf *BpfTracer) *LifecycleModel {
+	return &LifecycleModel{PID: pid, Proc: procInfo, BPF: bpf}
+}
+
+func (model *LifecycleModel) Init() tea.Cmd {
+	return nil
+}
+
+func (model *LifecycleModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
+	}
+	return model, nil
+}
+
+func (model *LifecycleModel) GetRegularStyle() lipgloss.Style {
+	return lipgloss.NewStyle().
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
+		BorderStyle(lipgloss.RoundedBorder())
+}
+
+func (model *LifecycleModel) GetFocusedStyle() lipgloss.Style {
+	return lipgloss.NewStyle().Inherit(model.GetRegularStyle()).
+		BorderForeground(lipgloss.Color(FocusedBorderForeground)).
+		BorderBackground(lipgloss.Color(FocusedBorderBackground))
+}
+
+func (model *LifecycleModel) View() string {
+	var ret string
+	ret += genericLabel.Render("Signals and process lifecycle") + "\n"
+	events := model.BPF.LatestLifecycleEvents(model.Height - 3)
+	if len(events) == 0 {
+		ret += "No data yet."
+		return ret
+	}
+	for _, evt := range events {
+		line := PathCaption(evt.String(), max(20, model.Width-4))
+		switch evt.Kind {
+		case LifecycleOOMKill, LifecycleCoreDump:
+			line = lifecycleFatalStyle.Render(line)
+		case LifecycleExit:
+			if evt.Signal != 0 {
+				line = lifecycleFatalStyle.Render(line)
+			}
+		}
+		ret += line + "\n"
+	}
+	return ret
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type ListenModel struct {
+	PID    int
+	BPF    *BpfTracer
+	Proc   *ProcInfo
+	Width  int
+	Height int
+}
+
+func NewListenModel(pid int, procInfo *ProcInfo, bpf *BpfTracer) *ListenModel {
+	return &ListenModel{PID: pid, Proc: procInfo, BPF: bpf}
+}
+
+func (model *ListenModel) Init() tea.Cmd {
+	return nil
+}
+
+func (model *ListenModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
+	}
+	return model, nil
+}
+
+func (model *ListenModel) GetRegularStyle() lipgloss.Style {
+	return lipgloss.NewStyle().
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
+		BorderStyle(lipgloss.RoundedBorder())
+}
+
+func (model *ListenModel) GetFocusedStyle() lipgloss.Style {
+	return lipgloss.NewStyle().Inherit(model.GetRegularStyle()).
+		BorderForeground(lipgloss.Color(FocusedBorderForeground)).
+		BorderBackground(lipgloss.Color(FocusedBorderBackground))
+}
+
+func (model *ListenModel) View() string {
+	var ret string
+	ret += genericLabel.Render("Listening sockets") + "\n"
+	listeners := model.BPF.ListenSocketSummary(model.Proc.Sockets)
+	if len(listeners) == 0 {
+		ret += "The process is not listening on any TCP or UDP socket."
+		return ret
+	}
+	for i, listener := range listeners {
+		if i == (model.Height-3)/2 {
+			break
+		}
+		if listener.Socket.Protocol == "udp" {
+			ret += fmt.Sprintf("%-3s %-28s recvq %s\n", listener.Socket.Protocol, PathCaption(listener.Socket.LocalCaption(), 28), ByteSizeCaption(listener.Socket.RxQueue))
+			continue
+		}
+		ret += fmt.Sprintf("%-3s %-28s backlog %-9s accept %d/s\n",
+			listener.Socket.Protocol, PathCaption(listener.Socket.LocalCaption(), 28),
+			BacklogCaption(listener.Socket.RxQueue, listener.MaxBacklog),
+			listener.Accepts/model.BPF.SamplingIntervalSec)
+		if hist := HistogramCaption(listener.FirstByteHist); hist != "" {
+			ret += "    first byte " + hist + "\n"
+		}
+	}
+	return ret
+}
+
+func BacklogCaption(current, max int) string {
+	if max == 0 {
+		return fmt.Sprintf("%d/?", current)
+	}
+	return fmt.Sprintf("%d/%d", current, max)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type LockModel struct {
+	PID    int
+	BPF    *BpfTracer
+	Proc   *ProcInfo
+	Width  int
+	Height int
+}
+
+func NewLockModel(pid int, procInfo *ProcInfo, bpf *BpfTracer) *LockModel {
+	return &LockModel{PID: pid, Proc: procInfo, BPF: bpf}
+}
+
+func (model *LockModel) Init() tea.Cmd {
+	return nil
+}
+
+func (model *LockModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
+	}
+	return model, nil
+}
+
+func (model *LockModel) GetRegularStyle() lipgloss.Style {
+	return lipgloss.NewStyle().
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
+		BorderStyle(lipgloss.RoundedBorder())
+}
+
+func (model *LockModel) GetFocusedStyle() lipgloss.Style {
+	return lipgloss.NewStyle().Inherit(model.GetRegularStyle()).
+		BorderForeground(lipgloss.Color(FocusedBorderForeground)).
+		BorderBackground(lipgloss.Color(FocusedBorderBackground))
+}
+
+func (model *LockModel) View() string {
+	var ret string
+	ret += genericLabel.Render("Lock contention - futex waits") + "\n"
+	locks := model.BPF.LockSummary()
+	if len(locks.ByWaitTime)+len(locks.GoMutexByCount) == 0 {
+		ret += "No data yet."
+		return ret
+	}
+	for i, futex := range locks.ByWaitTime {
+		if i == 5 {
+			break
+		}
+		ret += fmt.Sprintf("%#-14x %-6s %-3d waiters %-9s max wait %s\n",
+			futex.Address,
+			fmt.Sprintf("%d/s", futex.WaitCount/model.BPF.SamplingIntervalSec),
+			futex.Waiters,
+			futex.WaitTime.Round(time.Millisecond),
+			futex.MaxWait.Round(time.Millisecond))
+	}
+	ret += genericLabel.Render("Lock contention - call sites") + "\n"
+	for i, stack := range locks.ByStack {
+		if i == 3 {
+			break
+		}
+		ret += fmt.Sprintf("%-9s %s\n", stack.WaitTime.Round(time.Millisecond), PathCaption(StackCaption(stack.Stack, 3), max(20, model.Width-14)))
+	}
+	if len(model.BPF.GoMutexSymbols) > 0 {
+		ret += genericLabel.Render("Go sync.Mutex contention") + "\n"
+		for i, stack := range locks.GoMutexByCount {
+			if i == 3 {
+				break
+			}
+			ret += fmt.Sprintf("%-9s %s\n", fmt.Sprintf("%d/s", stack.Count/model.BPF.SamplingIntervalSec), PathCaption(StackCaption(stack.Stack, 4), max(20, model.Width-14)))
+		}
+	}
+	return ret
+}
@@ -2,7 +2,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -14,10 +20,48 @@ const (
 
 func main() {
 	var pid int
-	var promMetricsAddr, command string
+	var promMetricsAddr, command, events string
+	var tlsCaptureBytes, httpMaxRoutes, metricsTopK int
+	var metricsBy, metricsPathGlobs, identityLabels, otlpEndpoint, otlpProtocol string
+	var pushgatewayURL, remoteWriteURL, pushJob string
+	var filterPath, filterNet, layoutFile string
+	var metricsCert, metricsKey, metricsClientCA, metricsBasicAuth, metricsTokens, metricsEndpoints string
+	var otlpSlow, historyDuration time.Duration
+	var staticLabels LabelFlags
+	var eventErrorsOnly, headless, goMutex, tlsPlaintext, httpRequests, otlpInsecure bool
 	flag.IntVar(&pid, "p", 1, "The process ID to monitor")
 	flag.StringVar(&command, "comm", "", "Find process by this executable name (alternative to -p)")
-	flag.StringVar(&promMetricsAddr, "metricsaddr", "0.0.0.0:1619", "The host:port to start prometheus metrics server on")
+	flag.StringVar(&promMetricsAddr, "metricsaddr", "127.0.0.1:1619", "The host:port to start prometheus metrics server on")
+	flag.StringVar(&metricsCert, "metricscert", "", "The PEM certificate file to serve the metrics over TLS, used with -metricskey")
+	flag.StringVar(&metricsKey, "metricskey", "", "The PEM private key file of -metricscert")
+	flag.StringVar(&metricsClientCA, "metricsclientca", "", "With -metricscert, require the clients to present a certificate signed by a CA in this PEM file")
+	flag.StringVar(&metricsBasicAuth, "metricsbasicauth", "", "A file of user:password lines, one of which the clients must present using basic auth")
+	flag.StringVar(&metricsTokens, "metricstokens", "", "A file of bearer tokens, one per line, one of which the clients must present")
+	flag.StringVar(&metricsEndpoints, "metricsendpoints", "metrics,api,dashboard", "Comma separated endpoints to serve: metrics, api, and dashboard")
+	flag.StringVar(&events, "events", "", "Comma separated syscall names (e.g. openat,connect) to stream as individual events")
+	flag.BoolVar(&eventErrorsOnly, "eventerrors", false, "Only stream the syscall events that returned an error")
+	flag.BoolVar(&headless, "headless", false, "Print the syscall and lifecycle event stream and a summary line of each sampling interval to stdout instead of starting the terminal UI")
+	flag.BoolVar(&goMutex, "gomutex", false, "Trace the call sites of contended sync.Mutex if the process is a Go program")
+	flag.BoolVar(&tlsPlaintext, "tls", false, "Trace the plaintext of OpenSSL, BoringSSL, and Go crypto/tls connections (off by default, see the warning)")
+	flag.IntVar(&tlsCaptureBytes, "tlscapture", 0, fmt.Sprintf("With -tls, stream the first N (up to %d) plaintext bytes of each TLS read and write as events", MaxTLSCaptureBytes))
+	flag.BoolVar(&httpRequests, "http", false, "Measure the HTTP/1 requests by sampling the plaintext socket payloads, h2c requests only while their headers fit in the samples")
+	flag.IntVar(&httpMaxRoutes, "httproutes", 100, "With -http, the maximum number of distinct method and route pairs, the others are counted under route \"other\"")
+	flag.StringVar(&metricsBy, "metricsby", "", "Comma separated dimensions (path, remote, device) to export additional Prometheus series labelled by")
+	flag.IntVar(&metricsTopK, "metricstopk", 10, "With -metricsby, the number of label values of each dimension that get their own series, the busiest first, the others are counted under \"other\" till a series is idle for 5 minutes")
+	flag.StringVar(&metricsPathGlobs, "metricspaths", "", "With -metricsby=path, comma separated globs (e.g. /var/log/*.log) that collapse the matching paths into one series")
+	flag.StringVar(&identityLabels, "identity", "hostname,comm", "Comma separated labels that identify the process in the Prometheus metrics: "+strings.Join(IdentityLabelNames, ", "))
+	flag.Var(&staticLabels, "label", "A static key=value label added to the Prometheus metrics, may be repeated")
+	flag.StringVar(&otlpEndpoint, "otlpendpoint", "", "The host:port of an OpenTelemetry collector to push the metrics and the slow or failed events to")
+	flag.StringVar(&otlpProtocol, "otlpprotocol", OTLPProtocolGRPC, "The OTLP protocol to use with -otlpendpoint: grpc or http")
+	flag.BoolVar(&otlpInsecure, "otlpinsecure", false, "Connect to the -otlpendpoint without TLS")
+	flag.StringVar(&pushgatewayURL, "pushgateway", "", "The URL of a Prometheus Pushgateway to push the metrics to at each sampling interval and on exit")
+	flag.StringVar(&remoteWriteURL, "remotewrite", "", "The URL of a Prometheus remote_write endpoint (e.g. http://prometheus:9090/api/v1/write) to push the metrics to at each sampling interval and on exit")
+	flag.StringVar(&pushJob, "pushjob", "procshave", "The job label of the metrics pushed by -pushgateway and -remotewrite")
+	flag.DurationVar(&otlpSlow, "otlpslow", 100*time.Millisecond, "With -otlpendpoint, export the -events syscalls taking at least this long as spans, the failed ones are always exported")
+	flag.DurationVar(&historyDuration, "history", 5*time.Minute, "How far back the sparklines and the full-screen charts (press c) of the terminal UI go")
+	flag.StringVar(&filterPath, "filter-path", "", "Comma separated absolute path prefixes (e.g. /var/log/) to limit the file IO tracing to, in the kernel")
+	flag.StringVar(&filterNet, "filter-net", "", "Comma separated IP addresses and CIDR networks (e.g. 10.0.0.0/8) to limit the TCP traffic tracing to, in the kernel")
+	flag.StringVar(&layoutFile, "layout", "", "A file arranging the panels of the terminal UI, each line is a row of space separated panel names: "+strings.Join(PanelNames, ", "))
 	flag.Parse()
 
 	if command != "" {
@@ -27,22 +71,110 @@ func main() {
 	}
 
 	procInfo := NewProcInfo(pid)
-	metrics := NewMetricsCollector()
+	identity := ReadProcessIdentity(pid, procInfo)
+	labels, err := IdentityLabels(identity, identityLabels, staticLabels)
+	if err != nil {
+		log.Fatalf("Failed to parse -identity or -label: %v", err)
+	}
+	metrics := NewMetricsCollector(labels)
+	if metrics.Security, err = NewListenerSecurity(metricsCert, metricsKey, metricsClientCA, metricsBasicAuth, metricsTokens, metricsEndpoints); err != nil {
+		log.Fatalf("Failed to configure the metrics server: %v", err)
+	}
+	if _, err := metrics.Security.TLSConfig(); err != nil {
+		log.Fatalf("Failed to load the metrics server certificates: %v", err)
+	}
+	if promMetricsAddr != "" && !IsLoopbackAddress(promMetricsAddr) && !metrics.Security.RequiresAuth() && metricsClientCA == "" {
+		log.Printf("WARNING: the metrics server on %s is reachable from the network without authentication, "+
+			"consider -metricsbasicauth, -metricstokens, or -metricsclientca.", promMetricsAddr)
+	}
+	if metricsBy != "" {
+		var err error
+		if metrics.SeriesLimit, err = ParseSeriesLimit(metricsBy, metricsPathGlobs, metricsTopK); err != nil {
+			log.Fatalf("Failed to parse -metricsby: %v", err)
+		}
+	}
 	bpf := NewBpfTracer(pid, BPFSampleIntervalSec, metrics)
+	if events != "" {
+		var err error
+		if bpf.EventSyscalls, err = ParseEventSyscalls(events); err != nil {
+			log.Fatalf("Failed to parse -events: %v", err)
+		}
+		bpf.EventErrorsOnly = eventErrorsOnly
+	}
+	if goMutex {
+		if bpf.GoMutexSymbols = FindGoMutexSymbols(pid); len(bpf.GoMutexSymbols) == 0 {
+			log.Printf("The process does not appear to be a Go program with symbols, -gomutex is ignored.")
+		}
+	}
+	if tlsPlaintext {
+		log.Printf("WARNING: " + TLSPlaintextWarning)
+		if bpf.TLSTargets = FindTLSProbeTargets(pid); len(bpf.TLSTargets) == 0 {
+			log.Printf("The process does not appear to use OpenSSL, BoringSSL, or Go crypto/tls, -tls is ignored.")
+		}
+		bpf.TLSCaptureBytes = min(max(tlsCaptureBytes, 0), MaxTLSCaptureBytes)
+	} else if tlsCaptureBytes > 0 {
+		log.Fatalf("-tlscapture requires -tls")
+	}
+	if bpf.FilterPaths, err = ParsePathFilter(filterPath); err != nil {
+		log.Fatalf("Failed to parse -filter-path: %v", err)
+	}
+	if bpf.FilterNets, err = ParseNetFilter(filterNet); err != nil {
+		log.Fatalf("Failed to parse -filter-net: %v", err)
+	}
+	bpf.HTTPEnabled = httpRequests
+	bpf.HTTPMaxRoutes = httpMaxRoutes
+	// The cleanups deliver the final metrics and events on exit, including on SIGINT and SIGTERM in headless mode.
+	var cleanups []func()
+	cleanup := sync.OnceFunc(func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	})
+	defer cleanup()
+	if otlpEndpoint != "" {
+		exporter, err := NewOTLPExporter(otlpEndpoint, otlpProtocol, otlpInsecure, BPFSampleIntervalSec*time.Second, identity, procInfo)
+		if err != nil {
+			log.Fatalf("Failed to start the OTLP exporter: %v", err)
+		}
+		exporter.SlowThreshold = otlpSlow
+		exporter.Attach(bpf)
+		cleanups = append(cleanups, func() {
+			if err := exporter.Shutdown(); err != nil {
+				log.Printf("OTLP exporter error: %v", err)
+			}
+		})
+	}
+	metrics.API = NewAPIServer(pid, procInfo, bpf, identity)
+	metrics.API.Attach(bpf)
+	if pushgatewayURL != "" || remoteWriteURL != "" {
+		pusher := NewMetricsPusher(pushgatewayURL, remoteWriteURL, pushJob, labels)
+		pusher.Start(BPFSampleIntervalSec * time.Second)
+		cleanups = append(cleanups, pusher.Stop)
+	}
+	layout := DefaultPanelLayout()
+	if layoutFile != "" {
+		if layout, err = ReadPanelLayout(layoutFile); err != nil {
+			log.Fatalf("Failed to read -layout: %v", err)
+		}
+	}
+	history := NewHistory(int(historyDuration / (BPFSampleIntervalSec * time.Second)))
 	model := &MainModel{
-		ProcInfo:      procInfo,
-		BpfTracer:     bpf,
-		OverviewModel: NewOverviewModel(pid, procInfo, 1*time.Second),
-		FileModel:     NewFileModel(pid, procInfo, bpf),
-		NetModel:      NewNetModel(pid, procInfo, bpf),
-		BlkdevModel:   NewBlkdevModel(pid, procInfo, bpf),
+		History:        history,
+		Layout:         layout,
+		ProcInfo:       procInfo,
+		BpfTracer:      bpf,
+		OverviewModel:  NewOverviewModel(pid, procInfo, 1*time.Second, history),
+		FileModel:      NewFileModel(pid, procInfo, bpf, history),
+		NetModel:       NewNetModel(pid, procInfo, bpf, history),
+		BlkdevModel:    NewBlkdevModel(pid, procInfo, bpf, history),
+		SyscallModel:   NewSyscallModel(pid, procInfo, bpf, history),
+		EventModel:     NewEventModel(pid, procInfo, bpf),
+		LifecycleModel: NewLifecycleModel(pid, procInfo, bpf),
+		LockModel:      NewLockModel(pid, procInfo, bpf),
+		ListenModel:    NewListenModel(pid, procInfo, bpf),
+		RequestModel:   NewRequestModel(pid, procInfo, bpf),
 	}
 
-	go func() {
-		if err := model.BpfTracer.Start(); err != nil {
-			log.Printf("bpftrace error: %+v", err)
-		}
-	}()
 	if promMetricsAddr != "" {
 		go func() {
 			if err := metrics.Start(promMetricsAddr); err != nil {
@@ -50,7 +182,123 @@ func main() {
 			}
 		}()
 	}
+	if headless {
+		watchTargetExit(pid, bpf, func() {
+			cleanup()
+			os.Exit(0)
+		})
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+			<-signals
+			cleanup()
+			os.Exit(0)
+		}()
+		runHeadless(procInfo, bpf)
+		return
+	}
+	// The terminal UI stays to show how the target ended.
+	watchTargetExit(pid, bpf, cleanup)
+	go history.Start(procInfo, bpf)
+	go func() {
+		if err := model.BpfTracer.Start(); err != nil {
+			log.Printf("bpftrace error: %+v", err)
+		}
+	}()
 	if _, err := tea.NewProgram(model, tea.WithAltScreen()).Run(); err != nil {
-		log.Panic(err)
+		log.Print(err)
+	}
+}
+
+// watchTargetExit calls onExit once the target process has exited, so that the cleanups deliver the final metrics at
+// the end of a batch job without waiting for procshave to be stopped. The exit is told by the lifecycle event of the
+// target, or by its /proc directory disappearing.
+func watchTargetExit(pid int, bpf *BpfTracer, onExit func()) {
+	exited := make(chan struct{})
+	notify := sync.OnceFunc(func() {
+		close(exited)
+	})
+	onLifecycleEvent := bpf.OnLifecycleEvent
+	bpf.OnLifecycleEvent = func(evt LifecycleEvent) {
+		if onLifecycleEvent != nil {
+			onLifecycleEvent(evt)
+		}
+		if evt.Kind == LifecycleExit && evt.PID == pid {
+			notify()
+		}
+	}
+	go func() {
+		for range time.Tick(1 * time.Second) {
+			if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); err != nil {
+				notify()
+				return
+			}
+		}
+	}()
+	go func() {
+		<-exited
+		log.Printf("The process %d has exited, delivering the final metrics.", pid)
+		// Let bpftrace print the maps of the last sampling interval first.
+		time.Sleep(time.Duration(bpf.SamplingIntervalSec+1) * time.Second)
+		onExit()
+	}()
+}
+
+func runHeadless(procInfo *ProcInfo, bpf *BpfTracer) {
+	if len(bpf.TLSTargets) > 0 {
+		fmt.Println("WARNING: " + TLSPlaintextWarning)
+	}
+	go func() {
+		for range time.Tick(1 * time.Second) {
+			procInfo.Refresh()
+		}
+	}()
+	// The events are also given to the handlers set up earlier, such as the OTLP exporter.
+	onSyscallEvent := bpf.OnSyscallEvent
+	bpf.OnSyscallEvent = func(evt SyscallEvent) {
+		procInfo.Mutex.RLock()
+		fmt.Println(evt.Format(procInfo.TargetInfo.FDPath))
+		procInfo.Mutex.RUnlock()
+		if onSyscallEvent != nil {
+			onSyscallEvent(evt)
+		}
+	}
+	bpf.OnTLSEvent = func(evt TLSEvent) {
+		procInfo.Mutex.RLock()
+		defer procInfo.Mutex.RUnlock()
+		fmt.Println(evt.Format(procInfo.TargetInfo.FDPath))
+	}
+	onLifecycleEvent := bpf.OnLifecycleEvent
+	bpf.OnLifecycleEvent = func(evt LifecycleEvent) {
+		fmt.Println(evt.String())
+		if onLifecycleEvent != nil {
+			onLifecycleEvent(evt)
+		}
+	}
+	go printHeadlessSummaries(procInfo, bpf)
+	if err := bpf.Start(); err != nil {
+		log.Printf("bpftrace error: %+v", err)
+	}
+}
+
+// printHeadlessSummaries prints the totals of each sampling interval, so that the headless mode shows the activities of
+// the process without -events too.
+func printHeadlessSummaries(procInfo *ProcInfo, bpf *BpfTracer) {
+	history := NewHistory(1)
+	for range time.Tick(time.Duration(bpf.SamplingIntervalSec) * time.Second) {
+		history.Record(procInfo, bpf)
+		total := func(name string) float64 {
+			values := history.Values(HistoryKey(HistoryTotal, name))
+			if len(values) == 0 {
+				return 0
+			}
+			return values[len(values)-1]
+		}
+		fmt.Printf("%s summary: file read %s written %s, tcp in %s out %s, block device read %s written %s, syscalls %.0f/s errors %.0f/s, rss %s\n",
+			time.Now().Format("15:04:05.000000"),
+			RateCaption(total(TotalFileRead)), RateCaption(total(TotalFileWritten)),
+			RateCaption(total(TotalTCPIn)), RateCaption(total(TotalTCPOut)),
+			RateCaption(total(TotalBlkdevRead)), RateCaption(total(TotalBlkdevWritten)),
+			total(TotalSyscalls), total(TotalSyscallErrors), ByteSizeCaption(int(total(TotalResidentSetBytes))))
 	}
 }
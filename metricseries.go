@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	PathLabel       = "path"
+	RemoteAddrLabel = "remote_addr"
+	RemotePortLabel = "remote_port"
+	// OtherLabelValue replaces the label values that do not have a series of their own.
+	OtherLabelValue = "other"
+	// SeriesIdleTimeout is how long a label value keeps its series without being seen, before the series is deleted
+	// to make room for another value.
+	SeriesIdleTimeout = 5 * time.Minute
+)
+
+const (
+	SeriesByPath   = "path"
+	SeriesByRemote = "remote"
+	SeriesByDevice = "device"
+)
+
+// SeriesLimit selects the opt-in labelled series and bounds their cardinality.
+type SeriesLimit struct {
+	Dimensions map[string]bool
+	// TopK is the number of label values of each dimension that get their own series, the remaining values are added
+	// to the "other" series.
+	TopK int
+	// PathGlobs collapse the matching paths into a single series labelled by the glob.
+	PathGlobs []string
+	// lastSeen tells when the label values of each dimension that have their own series were last seen. A value keeps
+	// its series until it has been idle for SeriesIdleTimeout, so that a dimension never has more than TopK series
+	// besides "other".
+	lastSeen map[string]map[string]time.Time
+}
+
+// ParseSeriesLimit parses a comma separated list of dimensions (path, remote, device) and of path globs.
+func ParseSeriesLimit(dimensions, pathGlobs string, topK int) (*SeriesLimit, error) {
+	ret := &SeriesLimit{Dimensions: make(map[string]bool), TopK: topK, lastSeen: make(map[string]map[string]time.Time)}
+	for _, dimension := range strings.Split(dimensions, ",") {
+		dimension = strings.TrimSpace(dimension)
+		switch dimension {
+		case "":
+			continue
+		case SeriesByPath, SeriesByRemote, SeriesByDevice:
+			ret.Dimensions[dimension] = true
+		default:
+			return nil, fmt.Errorf("unknown dimension %q, expecting %s, %s, or %s", dimension, SeriesByPath, SeriesByRemote, SeriesByDevice)
+		}
+	}
+	for _, glob := range strings.Split(pathGlobs, ",") {
+		glob = strings.TrimSpace(glob)
+		if glob == "" {
+			continue
+		}
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("malformed path glob %q: %w", glob, err)
+		}
+		ret.PathGlobs = append(ret.PathGlobs, glob)
+	}
+	return ret, nil
+}
+
+func (limit *SeriesLimit) PathLabelValue(path string) string {
+	for _, glob := range limit.PathGlobs {
+		if matched, _ := filepath.Match(glob, path); matched {
+			return glob
+		}
+	}
+	return path
+}
+
+// TopKValues keeps the label values of the dimension that have a series, evicts those idle for SeriesIdleTimeout, gives
+// the free series of the dimension to the new values with the largest counts, and adds up the others under
+// OtherLabelValue. The caller deletes the series of the evicted values.
+func (limit *SeriesLimit) TopKValues(dimension string, counts map[string]float64) (ret map[string]float64, evicted []string) {
+	lastSeen := limit.lastSeen[dimension]
+	if lastSeen == nil {
+		lastSeen = make(map[string]time.Time)
+		limit.lastSeen[dimension] = lastSeen
+	}
+	now := time.Now()
+	for value, seen := range lastSeen {
+		if counts[value] == 0 && now.Sub(seen) > SeriesIdleTimeout {
+			delete(lastSeen, value)
+			evicted = append(evicted, value)
+		}
+	}
+	var sorted []string
+	for value := range counts {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return counts[sorted[i]] > counts[sorted[j]]
+	})
+	ret = make(map[string]float64)
+	for _, value := range sorted {
+		if _, exists := lastSeen[value]; !exists && len(lastSeen) < limit.TopK {
+			lastSeen[value] = now
+		}
+		if _, exists := lastSeen[value]; exists {
+			if counts[value] != 0 {
+				lastSeen[value] = now
+			}
+			ret[value] += counts[value]
+		} else {
+			ret[OtherLabelValue] += counts[value]
+		}
+	}
+	return ret, evicted
+}
+
+// countFDSeries adds the bytes of read or write syscalls to the series of each file path and remote endpoint.
+// Only the read and write syscalls are counted, sendmsg, recvmsg and the like are not.
+func (bpf *BpfTracer) countFDSeries(labels prometheus.Labels, values map[string]int, files, sockets *prometheus.CounterVec) {
+	limit := bpf.Metrics.SeriesLimit
+	if limit == nil || !(limit.Dimensions[SeriesByPath] || limit.Dimensions[SeriesByRemote]) {
+		return
+	}
+	var socketInfo map[uint64]*SocketInfo
+	byPath := make(map[string]float64)
+	byRemote := make(map[string]float64)
+	for fd, count := range values {
+		// The FD may have been closed since, in which case its bytes are left out.
+		target, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%s", bpf.PID, fd))
+		if err != nil {
+			continue
+		}
+		if inode, ok := SocketInode(target); ok && limit.Dimensions[SeriesByRemote] {
+			if socketInfo == nil {
+				socketInfo = ReadSockets(bpf.PID)
+			}
+			if sock := socketInfo[inode]; sock != nil && !sock.Listening() {
+				byRemote[sock.RemoteCaption()] += float64(count)
+			}
+		} else if strings.HasPrefix(target, "/") && limit.Dimensions[SeriesByPath] {
+			byPath[limit.PathLabelValue(target)] += float64(count)
+		}
+	}
+	pathCounts, evictedPaths := limit.TopKValues(SeriesByPath, byPath)
+	for path, count := range pathCounts {
+		files.With(withLabels(labels, prometheus.Labels{PathLabel: path})).Add(count)
+	}
+	for _, path := range evictedPaths {
+		bpf.Metrics.FileReadBytes.DeletePartialMatch(prometheus.Labels{PathLabel: path})
+		bpf.Metrics.FileWrittenBytes.DeletePartialMatch(prometheus.Labels{PathLabel: path})
+	}
+	remoteCounts, evictedRemotes := limit.TopKValues(SeriesByRemote, byRemote)
+	for endpoint, count := range remoteCounts {
+		addr, port := OtherLabelValue, OtherLabelValue
+		if endpoint != OtherLabelValue {
+			addr, port, _ = net.SplitHostPort(endpoint)
+		}
+		sockets.With(withLabels(labels, prometheus.Labels{RemoteAddrLabel: addr, RemotePortLabel: port})).Add(count)
+	}
+	for _, endpoint := range evictedRemotes {
+		addr, port, _ := net.SplitHostPort(endpoint)
+		bpf.Metrics.SocketReadBytes.DeletePartialMatch(prometheus.Labels{RemoteAddrLabel: addr, RemotePortLabel: port})
+		bpf.Metrics.SocketWrittenBytes.DeletePartialMatch(prometheus.Labels{RemoteAddrLabel: addr, RemotePortLabel: port})
+	}
+}
+
+// countDeviceSeries adds the values of a block IO map keyed by device and operation to the series of each device.
+func (bpf *BpfTracer) countDeviceSeries(labels prometheus.Labels, values map[string]int, multiplier float64, counter *prometheus.CounterVec) {
+	limit := bpf.Metrics.SeriesLimit
+	if limit == nil || !limit.Dimensions[SeriesByDevice] {
+		return
+	}
+	diskStats := ReadDiskStats()
+	byDevice := make(map[string]float64)
+	for key, value := range values {
+		majorMinor, _, ok := parseDevRwbsKey(key)
+		if !ok {
+			continue
+		}
+		device := majorMinor
+		if disk, exists := diskStats[majorMinor]; exists {
+			device = disk.DeviceName
+		}
+		byDevice[device] += float64(value) * multiplier
+	}
+	deviceCounts, evictedDevices := limit.TopKValues(SeriesByDevice, byDevice)
+	for device, count := range deviceCounts {
+		counter.With(withLabels(labels, prometheus.Labels{DeviceLabel: device})).Add(count)
+	}
+	for _, device := range evictedDevices {
+		bpf.Metrics.BlockDeviceIOBytes.DeletePartialMatch(prometheus.Labels{DeviceLabel: device})
+		bpf.Metrics.BlockDeviceIOOps.DeletePartialMatch(prometheus.Labels{DeviceLabel: device})
+	}
+}
+
+func withLabels(labels prometheus.Labels, extra prometheus.Labels) prometheus.Labels {
+	ret := make(prometheus.Labels)
+	for name, value := range labels {
+		ret[name] = value
+	}
+	for name, value := range extra {
+		ret[name] = value
+	}
+	return ret
+}
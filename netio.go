@@ -1,21 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
 type NetModel struct {
-	PID       int
-	BPF       *BpfTracer
-	Proc      *ProcInfo
-	TermWidth int
+	PID     int
+	BPF     *BpfTracer
+	Proc    *ProcInfo
+	History *History
+	Width   int
+	Height  int
+	Cursor  RowCursor
+	Sort    SortOrder
+	Filter  RowFilter
 }
 
-func NewNetModel(pid int, procInfo *ProcInfo, bpf *BpfTracer) *NetModel {
-	return &NetModel{PID: pid, Proc: procInfo, BPF: bpf}
+const (
+	NetSortBytes = "bytes"
+	NetSortPort  = "port"
+	NetSortIP    = "IP"
+)
+
+func NewNetModel(pid int, procInfo *ProcInfo, bpf *BpfTracer, history *History) *NetModel {
+	return &NetModel{PID: pid, Proc: procInfo, BPF: bpf, History: history, Sort: NewSortOrder(NetSortBytes, NetSortPort, NetSortIP)}
 }
 
 func (model *NetModel) Init() tea.Cmd {
@@ -24,15 +41,18 @@ func (model *NetModel) Init() tea.Cmd {
 
 func (model *NetModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
-	case tea.WindowSizeMsg:
-		model.TermWidth = msg.Width
+	case tea.KeyMsg:
+		model.Cursor.Update(msg)
+		model.Sort.Update(msg)
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
 	}
 	return model, nil
 }
 
 func (model *NetModel) GetRegularStyle() lipgloss.Style {
 	return lipgloss.NewStyle().
-		Width(model.TermWidth/2-2).Height(15).Align(lipgloss.Left, lipgloss.Top).
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
 		BorderStyle(lipgloss.RoundedBorder())
 }
 
@@ -42,25 +62,178 @@ func (model *NetModel) GetFocusedStyle() lipgloss.Style {
 		BorderBackground(lipgloss.Color(FocusedBorderBackground))
 }
 
+func (model *NetModel) GetFilter() *RowFilter {
+	return &model.Filter
+}
+
 func (model *NetModel) View() string {
-	var ret string
-	ret += genericLabel.Render("TCP activities - incoming") + "\n"
-	if len(model.BPF.TcpTrafficDestinations)+len(model.BPF.TcpTrafficSources) == 0 {
+	counters := model.sortedCounters()
+	var rowNames []string
+	for _, counter := range counters {
+		rowNames = append(rowNames, netDirection(counter)+" "+counter.Endpoint())
+	}
+	// Leave room for the socket pressure and TLS sections.
+	from, to := model.Cursor.SetRows(rowNames, model.Height-9)
+	ret := ListHeader("TCP activities", model.Sort, model.Cursor, model.Filter)
+	if len(model.BPF.TLSTargets) > 0 {
+		// The plaintext may contain secrets, remind whoever looks at the screen.
+		ret += " " + warningLabel.Render("[TLS plaintext traced]")
+	}
+	ret += "\n"
+	if len(counters) == 0 {
 		ret += "No data yet.\n"
-		return ret
 	}
-	for i, counter := range model.BPF.TcpTrafficDestinations {
-		if i == 6 {
+	for i := from; i < to; i++ {
+		counter := counters[i]
+		historyKind := HistoryTCPOut
+		if counter.IsDest {
+			historyKind = HistoryTCPIn
+		}
+		ret += model.Cursor.Render(i, fmt.Sprintf("%-3s %-39s %-5d %-9s %s", netDirection(counter), counter.IP, counter.Port, IORateCaption(counter.ByteCounter/model.BPF.SamplingIntervalSec),
+			model.History.Sparkline(HistoryKey(historyKind, counter.Endpoint()), SparklineWidth(model.Width, 60)))) + "\n"
+	}
+	ret += model.renderPressure()
+	ret += model.renderTLS()
+	return ret
+}
+
+func netDirection(counter BpfNetIOTrafficCounter) string {
+	if counter.IsDest {
+		return "in"
+	}
+	return "out"
+}
+
+// sortedCounters lists the incoming and outgoing endpoints together in the sort order.
+func (model *NetModel) sortedCounters() []BpfNetIOTrafficCounter {
+	counters := append(slices.Clone(model.BPF.TcpTrafficDestinations), model.BPF.TcpTrafficSources...)
+	sort.SliceStable(counters, func(i, j int) bool {
+		a, b := counters[i], counters[j]
+		switch model.Sort.Column() {
+		case NetSortPort:
+			return a.Port < b.Port
+		case NetSortIP:
+			return bytes.Compare(a.IP.To16(), b.IP.To16()) < 0
+		default:
+			return a.ByteCounter > b.ByteCounter
+		}
+	})
+	return slices.DeleteFunc(counters, func(counter BpfNetIOTrafficCounter) bool {
+		return !model.Filter.Match(netDirection(counter)+" "+counter.Endpoint(), counter.IP)
+	})
+}
+
+func (model *NetModel) OpenDetail() bool {
+	return model.Cursor.OpenDetail()
+}
+
+// DetailView shows the traffic of the selected TCP endpoint in both directions, and the connections of the target
+// to or from it.
+func (model *NetModel) DetailView(width, height int) string {
+	// The rows are named by the direction and the endpoint, the detail view covers both directions.
+	_, endpoint, _ := strings.Cut(model.Cursor.Detail, " ")
+	ret := genericLabel.Render("TCP endpoint "+endpoint) + "\n"
+	var incoming, outgoing int
+	for _, counter := range model.BPF.TcpTrafficDestinations {
+		if counter.Endpoint() == endpoint {
+			incoming += counter.ByteCounter
+		}
+	}
+	for _, counter := range model.BPF.TcpTrafficSources {
+		if counter.Endpoint() == endpoint {
+			outgoing += counter.ByteCounter
+		}
+	}
+	ret += fmt.Sprintf("Incoming %s, outgoing %s\n\n", IORateCaption(incoming/model.BPF.SamplingIntervalSec), IORateCaption(outgoing/model.BPF.SamplingIntervalSec))
+	ret += genericLabel.Render("Connections") + "\n"
+	host, portStr, _ := net.SplitHostPort(endpoint)
+	ip := net.ParseIP(host)
+	port, _ := strconv.Atoi(portStr)
+	var sockets []*SocketInfo
+	for _, sock := range model.Proc.Sockets {
+		if sock.Protocol == "tcp" && ((sock.RemoteIP.Equal(ip) && sock.RemotePort == port) || (sock.LocalIP.Equal(ip) && sock.LocalPort == port)) {
+			sockets = append(sockets, sock)
+		}
+	}
+	sort.Slice(sockets, func(i, j int) bool {
+		return sockets[i].Inode < sockets[j].Inode
+	})
+	if len(sockets) == 0 {
+		ret += "No open connection.\n"
+	}
+	for _, sock := range sockets {
+		inode := strconv.FormatUint(sock.Inode, 10)
+		ret += fmt.Sprintf("%-22s -> %-22s %-12s sendq %-12s recvq %s\n",
+			PathCaption(sock.LocalCaption(), 22), PathCaption(sock.RemoteCaption(), 22), sock.StateCaption(),
+			bufferCaption(sock.TxQueue, model.BPF.SocketSendBuf[inode]), bufferCaption(sock.RxQueue, model.BPF.SocketRecvBuf[inode]))
+	}
+	ret += "\n"
+	chartHeight := height - strings.Count(ret, "\n") - 1
+	return ret + model.History.RenderChart([]ChartSeries{
+		{Title: "Incoming", Key: HistoryKey(HistoryTCPIn, endpoint), Caption: RateCaption},
+		{Title: "Outgoing", Key: HistoryKey(HistoryTCPOut, endpoint), Caption: RateCaption},
+	}, width, chartHeight)
+}
+
+func (model *NetModel) renderTLS() string {
+	if len(model.BPF.TLSTargets) == 0 {
+		return ""
+	}
+	var ret string
+	ret += genericLabel.Render("TLS plaintext") + "\n"
+	tls := model.BPF.TLSSummary(model.Proc.TargetInfo.FDPath, model.Proc.Sockets)
+	for i, counter := range tls.ByBytes {
+		if i == 3 {
 			break
 		}
-		ret += fmt.Sprintf("%-39s %-5d %s\n", counter.IP, counter.Port, IORateCaption(counter.ByteCounter/model.BPF.SamplingIntervalSec))
+		ret += fmt.Sprintf("%-22s read %-9s write %s\n",
+			PathCaption(counter.Caption(), 22), IORateCaption(counter.ReadBytes/model.BPF.SamplingIntervalSec), IORateCaption(counter.WriteBytes/model.BPF.SamplingIntervalSec))
+	}
+	return ret
+}
+
+func bufferCaption(queued, limit int) string {
+	if limit == 0 {
+		return ByteSizeCaption(queued)
 	}
-	ret += genericLabel.Render("TCP activities - outgoing") + "\n"
-	for i, counter := range model.BPF.TcpTrafficSources {
-		if i == 6 {
+	return ByteSizeCaption(queued) + "/" + ByteSizeCaption(limit)
+}
+
+func (model *NetModel) renderPressure() string {
+	var ret string
+	pressure := model.BPF.SocketPressureSummary(model.Proc.Sockets)
+	ret += genericLabel.Render("Socket pressure") + "\n"
+	for i, sock := range pressure.ByQueueDepth {
+		if i == 2 || sock.Socket.TxQueue+sock.Socket.RxQueue == 0 {
 			break
 		}
-		ret += fmt.Sprintf("%-39s %-5d %s\n", counter.IP, counter.Port, IORateCaption(counter.ByteCounter/model.BPF.SamplingIntervalSec))
+		ret += fmt.Sprintf("%-22s sendq %-12s recvq %s\n",
+			PathCaption(sock.Socket.RemoteCaption(), 22), bufferCaption(sock.Socket.TxQueue, sock.SendBuf), bufferCaption(sock.Socket.RxQueue, sock.RecvBuf))
+	}
+	if len(pressure.DropsByReason) > 0 {
+		ret += "Dropped: " + TopCountsCaption(pressure.DropsByReason, 3) + "\n"
+	}
+	// Sort the listeners so that they keep their lines across refreshes.
+	var overflowed []uint64
+	for inode := range pressure.ListenOverflow {
+		overflowed = append(overflowed, inode)
+	}
+	sort.Slice(overflowed, func(i, j int) bool {
+		a, b := overflowed[i], overflowed[j]
+		return pressure.ListenOverflow[a] > pressure.ListenOverflow[b] || (pressure.ListenOverflow[a] == pressure.ListenOverflow[b] && a < b)
+	})
+	for _, inode := range overflowed {
+		count := pressure.ListenOverflow[inode]
+		sock := model.Proc.Sockets[inode]
+		ret += fmt.Sprintf("Listen %s backlog %s overflowed %d/s\n",
+			sock.LocalCaption(), BacklogCaption(sock.RxQueue, pressure.MaxBacklog[inode]), count/model.BPF.SamplingIntervalSec)
 	}
 	return ret
 }
+
+func (model *NetModel) ChartSeries() []ChartSeries {
+	return []ChartSeries{
+		{Title: "TCP bytes incoming", Key: HistoryKey(HistoryTotal, TotalTCPIn), Caption: RateCaption},
+		{Title: "TCP bytes outgoing", Key: HistoryKey(HistoryTotal, TotalTCPOut), Caption: RateCaption},
+	}
+}
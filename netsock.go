@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+const (
+	// Socket states as seen in /proc/net/tcp and udp.
+	TcpListenState      = 10
+	UdpUnconnectedState = 7
+)
+
+var (
+	// TcpStateNames are indexed by the socket states of /proc/net/tcp.
+	TcpStateNames   = []string{"", "ESTABLISHED", "SYN_SENT", "SYN_RECV", "FIN_WAIT1", "FIN_WAIT2", "TIME_WAIT", "CLOSE", "CLOSE_WAIT", "LAST_ACK", "LISTEN", "CLOSING", "NEW_SYN_RECV"}
+	SocketPathRegex = regexp.MustCompile(`^socket:\[([0-9]+)\]$`)
+	DropReasonRegex = regexp.MustCompile(`\{\s*([0-9]+),\s*"([A-Z0-9_]+)"\s*\}`)
+)
+
+type SocketInfo struct {
+	Inode      uint64
+	Protocol   string
+	LocalIP    net.IP
+	LocalPort  int
+	RemoteIP   net.IP
+	RemotePort int
+	State      int
+	// TxQueue and RxQueue are the bytes waiting in the send and receive queues.
+	// For a listening TCP socket, RxQueue is the current accept backlog.
+	TxQueue int
+	RxQueue int
+}
+
+// Listening tells the listening TCP sockets and the UDP servers. An unconnected UDP socket is only taken for a server
+// if it is bound to a port outside the ephemeral range, as the clients such as DNS resolvers get an ephemeral port.
+func (sock *SocketInfo) Listening() bool {
+	if sock.Protocol == "udp" && sock.State == UdpUnconnectedState {
+		low, high := ephemeralPortRange()
+		return sock.LocalPort < low || sock.LocalPort > high
+	}
+	return sock.Protocol == "tcp" && sock.State == TcpListenState
+}
+
+// ephemeralPortRange reads the range of the local ports assigned to the sockets that do not bind a port themselves.
+var ephemeralPortRange = sync.OnceValues(func() (int, int) {
+	content, err := os.ReadFile("/proc/sys/net/ipv4/ip_local_port_range")
+	if err != nil {
+		return 32768, 60999
+	}
+	var low, high int
+	if _, err := fmt.Sscan(string(content), &low, &high); err != nil {
+		return 32768, 60999
+	}
+	return low, high
+})
+
+func (sock *SocketInfo) StateCaption() string {
+	if sock.Protocol == "tcp" && sock.State > 0 && sock.State < len(TcpStateNames) {
+		return TcpStateNames[sock.State]
+	}
+	return strconv.Itoa(sock.State)
+}
+
+func (sock *SocketInfo) LocalCaption() string {
+	return net.JoinHostPort(sock.LocalIP.String(), strconv.Itoa(sock.LocalPort))
+}
+
+func (sock *SocketInfo) RemoteCaption() string {
+	return net.JoinHostPort(sock.RemoteIP.String(), strconv.Itoa(sock.RemotePort))
+}
+
+// SocketInode extracts the inode number from an FD path like "socket:[12345]".
+func SocketInode(fdPath string) (uint64, bool) {
+	match := SocketPathRegex.FindStringSubmatch(fdPath)
+	if len(match) != 2 {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(match[1], 10, 64)
+	return inode, err == nil
+}
+
+// ReadSockets returns the TCP and UDP sockets of the process keyed by inode, as seen in its network namespace.
+func ReadSockets(pid int) map[uint64]*SocketInfo {
+	ret := make(map[uint64]*SocketInfo)
+	fs, _ := procfs.NewDefaultFS()
+	proc, err := fs.Proc(pid)
+	if err != nil {
+		return ret
+	}
+	fdTargets, _ := proc.FileDescriptorTargets()
+	inodes := make(map[uint64]bool)
+	for _, target := range fdTargets {
+		if inode, ok := SocketInode(target); ok {
+			inodes[inode] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return ret
+	}
+	netFS, err := procfs.NewFS(fmt.Sprintf("/proc/%d", pid))
+	if err != nil {
+		return ret
+	}
+	add := func(protocol string, lines []*SocketInfo) {
+		for _, sock := range lines {
+			if inodes[sock.Inode] {
+				sock.Protocol = protocol
+				ret[sock.Inode] = sock
+			}
+		}
+	}
+	tcp, _ := netFS.NetTCP()
+	tcp6, _ := netFS.NetTCP6()
+	add("tcp", socketInfoFromTCP(append(tcp, tcp6...)))
+	udp, _ := netFS.NetUDP()
+	udp6, _ := netFS.NetUDP6()
+	add("udp", socketInfoFromUDP(append(udp, udp6...)))
+	return ret
+}
+
+func socketInfoFromTCP(lines procfs.NetTCP) []*SocketInfo {
+	var ret []*SocketInfo
+	for _, line := range lines {
+		ret = append(ret, &SocketInfo{
+			Inode: line.Inode, LocalIP: line.LocalAddr, LocalPort: int(line.LocalPort), RemoteIP: line.RemAddr, RemotePort: int(line.RemPort),
+			State: int(line.St), TxQueue: int(line.TxQueue), RxQueue: int(line.RxQueue),
+		})
+	}
+	return ret
+}
+
+func socketInfoFromUDP(lines procfs.NetUDP) []*SocketInfo {
+	var ret []*SocketInfo
+	for _, line := range lines {
+		ret = append(ret, &SocketInfo{
+			Inode: line.Inode, LocalIP: line.LocalAddr, LocalPort: int(line.LocalPort), RemoteIP: line.RemAddr, RemotePort: int(line.RemPort),
+			State: int(line.St), TxQueue: int(line.TxQueue), RxQueue: int(line.RxQueue),
+		})
+	}
+	return ret
+}
+
+// ReadSkbDropReasons reads the names of skb drop reasons from the kfree_skb tracepoint format, as their numbers vary
+// between kernel versions.
+func ReadSkbDropReasons() map[int]string {
+	ret := make(map[int]string)
+	for _, path := range []string{"/sys/kernel/tracing/events/skb/kfree_skb/format", "/sys/kernel/debug/tracing/events/skb/kfree_skb/format"} {
+		format, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, match := range DropReasonRegex.FindAllStringSubmatch(string(format), -1) {
+			reason, _ := strconv.Atoi(match[1])
+			ret[reason] = match[2]
+		}
+		break
+	}
+	return ret
+}
+
+func (bpf *BpfTracer) socketPressureProbes() string {
+	/*
+		The sockets of the target are remembered when it sends or receives, so that the packets dropped on those
+		sockets can be attributed to it, including while the sockets are stalled. They are forgotten when closed. A
+		socket is keyed by both its address and inode, as the address of a closed socket may be reused by another.
+		Listen queue overflow and the maximum backlog are recorded for all sockets on connection request, and filtered
+		later.
+	*/
+	return fmt.Sprintf(`
+kprobe:tcp_sendmsg,kprobe:tcp_recvmsg /pid == %d/ {
+    $sk = (struct sock *)arg0;
+    $ino = $sk->sk_socket->file->f_inode->i_ino;
+    @target_sock[(uint64)$sk, $ino] = 1;
+    @sk_sndbuf[$ino] = $sk->sk_sndbuf;
+    @sk_rcvbuf[$ino] = $sk->sk_rcvbuf;
+}
+tracepoint:skb:kfree_skb {
+    $sk = ((struct sk_buff *)args->skbaddr)->sk;
+    if ($sk != 0 && @target_sock[(uint64)$sk, $sk->sk_socket->file->f_inode->i_ino]) {
+        @skb_drops[args->reason] = count();
+    }
+}
+kprobe:tcp_close {
+    $sk = (struct sock *)arg0;
+    delete(@target_sock[(uint64)$sk, $sk->sk_socket->file->f_inode->i_ino]);
+}
+kprobe:tcp_conn_request {
+    $sk = (struct sock *)arg2;
+    @listen_max_backlog[$sk->sk_socket->file->f_inode->i_ino] = $sk->sk_max_ack_backlog;
+    if ($sk->sk_ack_backlog > $sk->sk_max_ack_backlog) {
+        @listen_overflow[$sk->sk_socket->file->f_inode->i_ino] = count();
+    }
+}
+kprobe:tcp_v4_syn_recv_sock,kprobe:tcp_v6_syn_recv_sock {
+    $sk = (struct sock *)arg0;
+    if ($sk->sk_ack_backlog > $sk->sk_max_ack_backlog) {
+        @listen_overflow[$sk->sk_socket->file->f_inode->i_ino] = count();
+    }
+}
+`, bpf.PID)
+}
+
+const socketPressureIntervalStatements = `
+    print(@sk_sndbuf); print(@sk_rcvbuf); print(@skb_drops); print(@listen_overflow);
+    clear(@sk_sndbuf); clear(@sk_rcvbuf); clear(@skb_drops); clear(@listen_overflow);`
+
+type SocketPressure struct {
+	Socket  *SocketInfo
+	SendBuf int
+	RecvBuf int
+}
+
+// skbDropReasonName turns a drop reason number like "2" into a name like "NOT_SPECIFIED".
+func (bpf *BpfTracer) skbDropReasonName(reasonStr string) string {
+	reason, _ := strconv.Atoi(reasonStr)
+	name, exists := bpf.SkbDropReasons[reason]
+	if !exists {
+		name = fmt.Sprintf("reason_%d", reason)
+	}
+	return strings.TrimPrefix(name, "SKB_DROP_REASON_")
+}
+
+type SocketPressureSummary struct {
+	ByQueueDepth   []*SocketPressure
+	DropsByReason  map[string]int
+	ListenOverflow map[uint64]int
+	MaxBacklog     map[uint64]int
+}
+
+func (bpf *BpfTracer) SocketPressureSummary(sockets map[uint64]*SocketInfo) *SocketPressureSummary {
+	ret := &SocketPressureSummary{
+		ByQueueDepth:   []*SocketPressure{},
+		DropsByReason:  make(map[string]int),
+		ListenOverflow: make(map[uint64]int),
+		MaxBacklog:     make(map[uint64]int),
+	}
+	for _, sock := range sockets {
+		if sock.Listening() {
+			continue
+		}
+		inode := strconv.FormatUint(sock.Inode, 10)
+		ret.ByQueueDepth = append(ret.ByQueueDepth, &SocketPressure{
+			Socket:  sock,
+			SendBuf: bpf.SocketSendBuf[inode],
+			RecvBuf: bpf.SocketRecvBuf[inode],
+		})
+	}
+	sort.Slice(ret.ByQueueDepth, func(i, j int) bool {
+		a := ret.ByQueueDepth[i].Socket
+		b := ret.ByQueueDepth[j].Socket
+		return a.TxQueue+a.RxQueue > b.TxQueue+b.RxQueue
+	})
+	for reason, count := range bpf.SkbDrops {
+		ret.DropsByReason[bpf.skbDropReasonName(reason)] += count
+	}
+	ret.ListenOverflow = targetListenOverflows(bpf.ListenOverflow, sockets)
+	for inode := range ret.ListenOverflow {
+		ret.MaxBacklog[inode] = bpf.ListenMaxBacklog[strconv.FormatUint(inode, 10)]
+	}
+	return ret
+}
+
+// targetListenOverflows keeps the listen queue overflows of the listening sockets among the sockets of the target, the
+// overflows are recorded for the listening sockets of all processes.
+func targetListenOverflows(overflows map[string]int, sockets map[uint64]*SocketInfo) map[uint64]int {
+	ret := make(map[uint64]int)
+	for inodeStr, count := range overflows {
+		inode, _ := strconv.ParseUint(inodeStr, 10, 64)
+		if sock, exists := sockets[inode]; exists && sock.Listening() {
+			ret[inode] += count
+		}
+	}
+	return ret
+}
+
+func (bpf *BpfTracer) acceptProbes() string {
+	/*
+		Accepted sockets are remembered along with their listening socket, the first successful receive on them
+		measures the latency from accept to first byte. The sockets closed before receiving anything are forgotten.
+	*/
+	return fmt.Sprintf(`
+kprobe:inet_csk_accept /pid == %d/ {
+    @accept_listen_sk[tid] = arg0;
+    $lsk = (struct sock *)arg0;
+    @listen_max_backlog[$lsk->sk_socket->file->f_inode->i_ino] = $lsk->sk_max_ack_backlog;
+}
+kretprobe:inet_csk_accept /@accept_listen_sk[tid]/ {
+    $lsk = (struct sock *)@accept_listen_sk[tid];
+    $ino = $lsk->sk_socket->file->f_inode->i_ino;
+    @accepts[$ino] = count();
+    if (retval != 0) {
+        @accepted_at[retval] = nsecs;
+        @accepted_from[retval] = $ino;
+    }
+    delete(@accept_listen_sk[tid]);
+}
+kprobe:tcp_recvmsg /pid == %d && @accepted_at[arg0]/ {
+    @recv_sk[tid] = arg0;
+}
+kretprobe:tcp_recvmsg /@recv_sk[tid]/ {
+    $sk = @recv_sk[tid];
+    if ((int64)retval > 0) {
+        @accept_first_byte_hist[@accepted_from[$sk]] = hist((nsecs - @accepted_at[$sk]) / 1000);
+        delete(@accepted_at[$sk]);
+        delete(@accepted_from[$sk]);
+    }
+    delete(@recv_sk[tid]);
+}
+kprobe:tcp_close /@accepted_at[arg0]/ {
+    delete(@accepted_at[arg0]);
+    delete(@accepted_from[arg0]);
+}
+`, bpf.PID, bpf.PID)
+}
+
+// The maximum backlog is not cleared as it only changes when the socket starts listening.
+const acceptIntervalStatements = `
+    print(@accepts); print(@accept_first_byte_hist); print(@listen_max_backlog);
+    clear(@accepts); clear(@accept_first_byte_hist);`
+
+type ListenSocketSummary struct {
+	Socket  *SocketInfo
+	Accepts int
+	// MaxBacklog is only known after the socket has received a connection request, it is 0 till then.
+	MaxBacklog int
+	// FirstByteHist is the histogram of latency from accept to first byte received, in microseconds.
+	FirstByteHist []BpfHistBucket
+}
+
+func (bpf *BpfTracer) ListenSocketSummary(sockets map[uint64]*SocketInfo) []*ListenSocketSummary {
+	ret := []*ListenSocketSummary{}
+	for _, sock := range sockets {
+		if !sock.Listening() {
+			continue
+		}
+		inode := strconv.FormatUint(sock.Inode, 10)
+		ret = append(ret, &ListenSocketSummary{
+			Socket:        sock,
+			Accepts:       bpf.Accepts[inode],
+			MaxBacklog:    bpf.ListenMaxBacklog[inode],
+			FirstByteHist: bpf.AcceptFirstByteHist[inode],
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a := ret[i].Socket
+		b := ret[j].Socket
+		if a.LocalPort != b.LocalPort {
+			return a.LocalPort < b.LocalPort
+		}
+		return a.Protocol < b.Protocol
+	})
+	return ret
+}
+
+func (bpf *BpfTracer) observeAcceptFirstByteHist(hist map[string][]BpfHistBucket) {
+	if bpf.Metrics == nil {
+		return
+	}
+	labels := prometheus.Labels{}
+	for _, buckets := range hist {
+		for _, bucket := range buckets {
+			if bucket.Min == nil || bucket.Max == nil {
+				continue
+			}
+			sum := float64(*bucket.Min+*bucket.Max) / 2 * float64(bucket.Count) / 1e6
+			bpf.Metrics.AcceptFirstByteSeconds.Observe(labels, float64(*bucket.Max)/1e6, uint64(bucket.Count), sum)
+		}
+	}
+}
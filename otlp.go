@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	otelprom "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+	OTLPScopeName    = "github.com/HouzuoGuo/procshave"
+)
+
+// OTLPExporter pushes the Prometheus metrics and the slow or failed events of the process to an OpenTelemetry
+// collector.
+type OTLPExporter struct {
+	ProcInfo *ProcInfo
+	// SlowThreshold is the minimum duration of a syscall event exported as a span, the failed syscalls are always
+	// exported.
+	SlowThreshold time.Duration
+
+	meterProvider  *sdkmetric.MeterProvider
+	tracerProvider *sdktrace.TracerProvider
+	tracer         trace.Tracer
+}
+
+// NewOTLPExporter connects to the collector at endpoint (host:port) using the protocol grpc or http, and starts
+// pushing the metrics gathered from the default Prometheus registry every interval.
+func NewOTLPExporter(endpoint, protocol string, insecure bool, interval time.Duration, identity map[string]string, procInfo *ProcInfo) (*OTLPExporter, error) {
+	ctx := context.Background()
+	var metricExporter sdkmetric.Exporter
+	var traceExporter sdktrace.SpanExporter
+	var err error
+	switch protocol {
+	case OTLPProtocolGRPC:
+		metricOpts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(endpoint)}
+		traceOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+		if insecure {
+			metricOpts = append(metricOpts, otlpmetricgrpc.WithInsecure())
+			traceOpts = append(traceOpts, otlptracegrpc.WithInsecure())
+		}
+		if metricExporter, err = otlpmetricgrpc.New(ctx, metricOpts...); err != nil {
+			return nil, err
+		}
+		traceExporter, err = otlptracegrpc.New(ctx, traceOpts...)
+	case OTLPProtocolHTTP:
+		metricOpts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(endpoint)}
+		traceOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+		if insecure {
+			metricOpts = append(metricOpts, otlpmetrichttp.WithInsecure())
+			traceOpts = append(traceOpts, otlptracehttp.WithInsecure())
+		}
+		if metricExporter, err = otlpmetrichttp.New(ctx, metricOpts...); err != nil {
+			return nil, err
+		}
+		traceExporter, err = otlptracehttp.New(ctx, traceOpts...)
+	default:
+		return nil, fmt.Errorf("unknown protocol %q, expecting %s or %s", protocol, OTLPProtocolGRPC, OTLPProtocolHTTP)
+	}
+	if err != nil {
+		return nil, err
+	}
+	reader := sdkmetric.NewPeriodicReader(metricExporter,
+		sdkmetric.WithInterval(interval),
+		sdkmetric.WithProducer(otelprom.NewMetricProducer(otelprom.WithGatherer(prometheus.DefaultGatherer))))
+	return newOTLPExporter(reader, sdktrace.NewBatchSpanProcessor(traceExporter), identity, procInfo), nil
+}
+
+// newOTLPExporter exports the metrics through the reader and the spans through the processor, which are connected to
+// a collector or, in the tests, kept in memory.
+func newOTLPExporter(reader sdkmetric.Reader, spans sdktrace.SpanProcessor, identity map[string]string, procInfo *ProcInfo) *OTLPExporter {
+	res := resource.NewWithAttributes(semconv.SchemaURL, OTLPResourceAttributes(identity)...)
+	exporter := &OTLPExporter{
+		ProcInfo:       procInfo,
+		meterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithResource(res), sdkmetric.WithReader(reader)),
+		tracerProvider: sdktrace.NewTracerProvider(sdktrace.WithResource(res), sdktrace.WithSpanProcessor(spans)),
+	}
+	exporter.tracer = exporter.tracerProvider.Tracer(OTLPScopeName)
+	return exporter
+}
+
+// OTLPResourceAttributes translates the identity labels of the process to the OpenTelemetry semantic conventions.
+func OTLPResourceAttributes(identity map[string]string) []attribute.KeyValue {
+	var ret []attribute.KeyValue
+	if pid, err := strconv.Atoi(identity[PidLabel]); err == nil {
+		ret = append(ret, semconv.ProcessPID(pid))
+	}
+	if comm := identity[CommLabel]; comm != "" {
+		ret = append(ret, semconv.ServiceName(comm), semconv.ProcessExecutableName(comm))
+	}
+	conventions := map[string]func(string) attribute.KeyValue{
+		HostnameLabel:      semconv.HostName,
+		ExeLabel:           semconv.ProcessExecutablePath,
+		ContainerIDLabel:   semconv.ContainerID,
+		ContainerNameLabel: semconv.ContainerName,
+		K8sNamespaceLabel:  semconv.K8SNamespaceName,
+		K8sPodLabel:        semconv.K8SPodName,
+		K8sPodUIDLabel:     semconv.K8SPodUID,
+		SystemdUnitLabel:   attribute.Key("systemd.unit").String,
+	}
+	for name, convention := range conventions {
+		if value := identity[name]; value != "" {
+			ret = append(ret, convention(value))
+		}
+	}
+	return ret
+}
+
+// Attach exports the events of the tracer in addition to its existing event handlers.
+func (exporter *OTLPExporter) Attach(bpf *BpfTracer) {
+	onSyscallEvent := bpf.OnSyscallEvent
+	bpf.OnSyscallEvent = func(evt SyscallEvent) {
+		exporter.ExportSyscallEvent(evt)
+		if onSyscallEvent != nil {
+			onSyscallEvent(evt)
+		}
+	}
+	onLifecycleEvent := bpf.OnLifecycleEvent
+	bpf.OnLifecycleEvent = func(evt LifecycleEvent) {
+		exporter.ExportLifecycleEvent(evt)
+		if onLifecycleEvent != nil {
+			onLifecycleEvent(evt)
+		}
+	}
+}
+
+// ExportSyscallEvent exports the syscall as a span if it took at least SlowThreshold or failed.
+func (exporter *OTLPExporter) ExportSyscallEvent(evt SyscallEvent) {
+	if evt.Duration < exporter.SlowThreshold && evt.Ret >= 0 {
+		return
+	}
+	exporter.ProcInfo.Mutex.RLock()
+	desc := evt.Format(exporter.ProcInfo.TargetInfo.FDPath)
+	exporter.ProcInfo.Mutex.RUnlock()
+	attrs := []attribute.KeyValue{
+		semconv.ThreadID(evt.TID),
+		attribute.String("syscall.name", evt.Name),
+		attribute.Int64("syscall.return", evt.Ret),
+		attribute.String("syscall.description", desc),
+	}
+	if evt.Path != "" {
+		attrs = append(attrs, semconv.FilePath(evt.Path))
+	}
+	if evt.Address != "" {
+		attrs = append(attrs, semconv.NetworkPeerAddress(evt.Address))
+	}
+	_, span := exporter.tracer.Start(context.Background(), evt.Name, trace.WithTimestamp(evt.Time), trace.WithAttributes(attrs...))
+	if evt.Ret < 0 {
+		errno := ErrnoName(int(-evt.Ret))
+		span.SetAttributes(semconv.ErrorTypeKey.String(errno))
+		span.SetStatus(codes.Error, errno)
+	}
+	span.End(trace.WithTimestamp(evt.Time.Add(evt.Duration)))
+}
+
+// ExportLifecycleEvent exports the OOM kills, core dumps, and deaths by signal as spans with an error status.
+func (exporter *OTLPExporter) ExportLifecycleEvent(evt LifecycleEvent) {
+	switch {
+	case evt.Kind == LifecycleOOMKill, evt.Kind == LifecycleCoreDump:
+	case evt.Kind == LifecycleExit && evt.Signal != 0:
+	default:
+		return
+	}
+	attrs := []attribute.KeyValue{
+		semconv.ProcessPID(evt.PID),
+		semconv.ProcessExecutableName(evt.Comm),
+		attribute.String("lifecycle.kind", evt.Kind),
+	}
+	if evt.Signal != 0 {
+		attrs = append(attrs, attribute.String("signal.name", SignalName(evt.Signal)))
+	}
+	_, span := exporter.tracer.Start(context.Background(), evt.Kind, trace.WithTimestamp(evt.Time), trace.WithAttributes(attrs...))
+	span.SetStatus(codes.Error, evt.String())
+	span.End(trace.WithTimestamp(evt.Time))
+}
+
+// Shutdown pushes the remaining metrics and spans before closing the connections to the collector.
+func (exporter *OTLPExporter) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return errors.Join(exporter.tracerProvider.Shutdown(ctx), exporter.meterProvider.Shutdown(ctx))
+}
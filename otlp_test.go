@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// newTestOTLPExporter returns an exporter that keeps its spans in memory in place of a collector.
+func newTestOTLPExporter(t *testing.T) (*OTLPExporter, *tracetest.InMemoryExporter) {
+	t.Helper()
+	spans := tracetest.NewInMemoryExporter()
+	identity := map[string]string{PidLabel: "1234", HostnameLabel: "web-1", CommLabel: "nginx", K8sPodLabel: "web-7f9c"}
+	procInfo := &ProcInfo{Mutex: new(sync.RWMutex), TargetInfo: &ProcessInfo{FDPath: map[int]string{3: "/var/log/access.log"}}}
+	exporter := newOTLPExporter(sdkmetric.NewManualReader(), sdktrace.NewSimpleSpanProcessor(spans), identity, procInfo)
+	exporter.SlowThreshold = 100 * time.Millisecond
+	t.Cleanup(func() {
+		if err := exporter.Shutdown(); err != nil {
+			t.Error(err)
+		}
+	})
+	return exporter, spans
+}
+
+func TestOTLPExporterSyscallEvents(t *testing.T) {
+	exporter, spans := newTestOTLPExporter(t)
+	start := time.Now()
+	// Fast and successful.
+	exporter.ExportSyscallEvent(SyscallEvent{Time: start, Duration: 10 * time.Millisecond, TID: 1, Name: "fsync", Args: [3]int64{3}})
+	// Slow and successful.
+	exporter.ExportSyscallEvent(SyscallEvent{Time: start, Duration: 200 * time.Millisecond, TID: 2, Name: "fsync", Args: [3]int64{3}})
+	// Fast and failed with ENOENT.
+	exporter.ExportSyscallEvent(SyscallEvent{Time: start, Duration: time.Millisecond, TID: 3, Name: "openat", Ret: -2, Path: "/etc/missing"})
+
+	got := spans.GetSpans()
+	if len(got) != 2 {
+		t.Fatalf("got %d spans, want the slow and the failed syscall: %+v", len(got), got)
+	}
+	slow, failed := got[0], got[1]
+	if slow.Name != "fsync" || slow.Status.Code != codes.Unset || slow.EndTime.Sub(slow.StartTime) != 200*time.Millisecond {
+		t.Errorf("unexpected slow syscall span: %+v", slow)
+	}
+	if !hasAttribute(slow.Attributes, semconv.ThreadID(2)) {
+		t.Errorf("slow syscall span lacks the thread ID: %+v", slow.Attributes)
+	}
+	if failed.Name != "openat" || failed.Status.Code != codes.Error || failed.Status.Description != "ENOENT" {
+		t.Errorf("unexpected failed syscall span: %+v", failed)
+	}
+	if !hasAttribute(failed.Attributes, semconv.FilePath("/etc/missing")) || !hasAttribute(failed.Attributes, semconv.ErrorTypeKey.String("ENOENT")) {
+		t.Errorf("failed syscall span lacks the path or error type: %+v", failed.Attributes)
+	}
+	for _, want := range []attribute.KeyValue{
+		semconv.ProcessPID(1234), semconv.HostName("web-1"), semconv.ServiceName("nginx"), semconv.K8SPodName("web-7f9c"),
+	} {
+		if !hasAttribute(slow.Resource.Attributes(), want) {
+			t.Errorf("resource lacks %v: %v", want, slow.Resource.Attributes())
+		}
+	}
+}
+
+func TestOTLPExporterLifecycleEvents(t *testing.T) {
+	exporter, spans := newTestOTLPExporter(t)
+	now := time.Now()
+	exporter.ExportLifecycleEvent(LifecycleEvent{Time: now, Kind: LifecycleSignalDeliver, PID: 1234, Comm: "nginx", Signal: 15})
+	exporter.ExportLifecycleEvent(LifecycleEvent{Time: now, Kind: LifecycleExit, PID: 1234, Comm: "nginx"})
+	exporter.ExportLifecycleEvent(LifecycleEvent{Time: now, Kind: LifecycleExit, PID: 1235, Comm: "nginx", Signal: 9})
+	exporter.ExportLifecycleEvent(LifecycleEvent{Time: now, Kind: LifecycleOOMKill, PID: 1236, Comm: "nginx", Signal: 9})
+	exporter.ExportLifecycleEvent(LifecycleEvent{Time: now, Kind: LifecycleCoreDump, PID: 1237, Comm: "nginx"})
+
+	got := spans.GetSpans()
+	if len(got) != 3 {
+		t.Fatalf("got %d spans, want the death by signal, OOM kill, and core dump: %+v", len(got), got)
+	}
+	for i, want := range []struct {
+		kind string
+		pid  int
+	}{{LifecycleExit, 1235}, {LifecycleOOMKill, 1236}, {LifecycleCoreDump, 1237}} {
+		span := got[i]
+		if span.Name != want.kind || span.Status.Code != codes.Error || !hasAttribute(span.Attributes, semconv.ProcessPID(want.pid)) {
+			t.Errorf("unexpected span %d: %+v", i, span)
+		}
+	}
+	if !hasAttribute(got[0].Attributes, attribute.String("signal.name", "SIGKILL")) {
+		t.Errorf("death by signal span lacks the signal name: %+v", got[0].Attributes)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == want {
+			return true
+		}
+	}
+	return false
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -21,14 +22,17 @@ type OverviewModel struct {
 	PID         int
 	RefreshRate time.Duration
 	Proc        *ProcInfo
-	TermWidth   int
+	History     *History
+	Width       int
+	Height      int
 }
 
-func NewOverviewModel(pid int, procInfo *ProcInfo, refreshRate time.Duration) *OverviewModel {
+func NewOverviewModel(pid int, procInfo *ProcInfo, refreshRate time.Duration, history *History) *OverviewModel {
 	return &OverviewModel{
 		PID:         pid,
 		RefreshRate: refreshRate,
 		Proc:        procInfo,
+		History:     history,
 	}
 }
 
@@ -42,20 +46,15 @@ func (model *OverviewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case RefreshMessage:
 		model.Proc.Refresh()
 		return model, refreshAfter(model.RefreshRate)
-	case tea.WindowSizeMsg:
-		model.TermWidth = msg.Width
-	case tea.KeyMsg:
-		switch msg.String() {
-		case tea.KeyEnter.String():
-			log.Printf("@@@@@ got enter")
-		}
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
 	}
 	return model, nil
 }
 
 func (model *OverviewModel) GetRegularStyle() lipgloss.Style {
 	return lipgloss.NewStyle().
-		Width(model.TermWidth/2-2).Height(15).Align(lipgloss.Left, lipgloss.Top).
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
 		BorderStyle(lipgloss.RoundedBorder())
 }
 
@@ -75,11 +74,11 @@ func (model *OverviewModel) renderHierarchy() string {
 	ret += fmt.Sprintf("%s %s\n", genericLabel.Render("Exe:  "), target.MainExec)
 	ret += fmt.Sprintf("%s %s\n", genericLabel.Render("Cwd:  "), target.MainCWD)
 	ret += fmt.Sprintf("%s %v\n\n", genericLabel.Render("Since:"), time.Duration(model.Proc.Uptime-time.Duration(model.Proc.TargetInfo.StartSecSinceBoot)*time.Second).Round(1*time.Second))
-	ret += fmt.Sprintf("%s %s %d %s (%s:%s)\n",
+	ret += fmt.Sprintf("%s %s %d %s (%d:%d)\n",
 		genericLabel.Render("┌Session   "), renderTaskState(session.MainStat.State, session.MainStat.State),
 		session.MainStat.PID, session.MainComm, session.MainStatus.UIDs[0], session.MainStatus.GIDs[0])
 	if tty.PID > 0 {
-		ret += fmt.Sprintf("%s %s %d %s (%s:%s)\n",
+		ret += fmt.Sprintf("%s %s %d %s (%d:%d)\n",
 			genericLabel.Render("├─TTY group"),
 			renderTaskState(tty.MainStat.State, tty.MainStat.State), tty.MainStat.PID, tty.MainComm,
 			tty.MainStatus.UIDs[0], tty.MainStatus.GIDs[0])
@@ -87,21 +86,21 @@ func (model *OverviewModel) renderHierarchy() string {
 		ret += fmt.Sprintf("%s\n", genericLabel.Render("├─TTY group not used"))
 	}
 	if parent.MainStat.PID < group.MainStat.PID {
-		ret += fmt.Sprintf("%s %s %d %s (%s:%s)\n",
+		ret += fmt.Sprintf("%s %s %d %s (%d:%d)\n",
 			genericLabel.Render("└┬Parent   "), renderTaskState(parent.MainStat.State, parent.MainStat.State),
 			parent.MainStat.PID, parent.MainComm, parent.MainStatus.UIDs[0], parent.MainStatus.GIDs[0])
-		ret += fmt.Sprintf("%s %s %d %s (%s:%s)\n",
+		ret += fmt.Sprintf("%s %s %d %s (%d:%d)\n",
 			genericLabel.Render(" └┬Group   "), renderTaskState(group.MainStat.State, group.MainStat.State),
 			group.MainStat.PID, group.MainComm, group.MainStatus.UIDs[0], group.MainStatus.GIDs[0])
 	} else {
-		ret += fmt.Sprintf("%s %s %d %s (%s:%s)\n",
+		ret += fmt.Sprintf("%s %s %d %s (%d:%d)\n",
 			genericLabel.Render("└┬Group    "), renderTaskState(group.MainStat.State, group.MainStat.State),
 			group.MainStat.PID, group.MainComm, group.MainStatus.UIDs[0], group.MainStatus.GIDs[0])
-		ret += fmt.Sprintf("%s %s %d %s (%s:%s)\n",
+		ret += fmt.Sprintf("%s %s %d %s (%d:%d)\n",
 			genericLabel.Render(" └┬Parent  "), renderTaskState(parent.MainStat.State, parent.MainStat.State),
 			parent.MainStat.PID, parent.MainComm, parent.MainStatus.UIDs[0], parent.MainStatus.GIDs[0])
 	}
-	ret += fmt.Sprintf("%sTarget • %s %d %s (%s:%s)\n",
+	ret += fmt.Sprintf("%sTarget • %s %d %s (%d:%d)\n",
 		genericLabel.Render("  └"), renderTaskState(target.MainStat.State, target.MainStat.State),
 		target.MainStat.PID, target.MainComm, target.MainStatus.UIDs[0], target.MainStatus.GIDs[0])
 	return ret + "\n"
@@ -144,6 +143,8 @@ func (model *OverviewModel) renderResourceUsage() string {
 			renderTaskState("other", fmt.Sprintf("%-3d other", other)),
 		)
 	}
+	ret += fmt.Sprintf("\n%s%-8s %s", genericLabel.Render("RSS: "), ByteSizeCaption(int(model.Proc.TargetInfo.MainStatus.VmRSS)),
+		model.History.Sparkline(HistoryKey(HistoryTotal, TotalResidentSetBytes), SparklineWidth(model.Width, 14)))
 	return ret
 }
 
@@ -153,3 +154,36 @@ func (model *OverviewModel) View() string {
 	ret += model.renderResourceUsage()
 	return ret
 }
+
+func (model *OverviewModel) OpenDetail() bool {
+	return true
+}
+
+// DetailView shows the full /proc status of the target, in as many columns as it takes to fit the height.
+func (model *OverviewModel) DetailView(width, height int) string {
+	ret := genericLabel.Render(fmt.Sprintf("/proc/%d/status", model.PID)) + "\n"
+	status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", model.PID))
+	if err != nil {
+		return ret + err.Error()
+	}
+	lines := strings.Split(strings.TrimSpace(string(status)), "\n")
+	rows := max(1, height-1)
+	var columns []string
+	for i := 0; i < len(lines); i += rows {
+		column := lines[i:min(len(lines), i+rows)]
+		for j, line := range column {
+			key, value, _ := strings.Cut(line, ":")
+			column[j] = fmt.Sprintf("%s %s", genericLabel.Render(key+":"), strings.Join(strings.Fields(value), " "))
+		}
+		columns = append(columns, lipgloss.NewStyle().PaddingRight(2).Render(strings.Join(column, "\n")))
+	}
+	return ret + lipgloss.NewStyle().MaxWidth(width).Render(lipgloss.JoinHorizontal(lipgloss.Top, columns...))
+}
+
+func (model *OverviewModel) ChartSeries() []ChartSeries {
+	return []ChartSeries{
+		{Title: "Running threads", Key: HistoryKey(HistoryTotal, TotalThreadsRunning), Caption: CountCaption},
+		{Title: "Sleeping threads", Key: HistoryKey(HistoryTotal, TotalThreadsSleeping), Caption: CountCaption},
+		{Title: "Resident set size", Key: HistoryKey(HistoryTotal, TotalResidentSetBytes), Caption: func(value float64) string { return ByteSizeCaption(int(value)) }},
+	}
+}
@@ -9,8 +9,19 @@ import (
 	"github.com/prometheus/procfs"
 	"github.com/prometheus/procfs/blockdevice"
 	"github.com/tklauser/go-sysconf"
+	"golang.org/x/sys/unix"
 )
 
+// FileID identifies a file by the device number of its file system and inode number.
+type FileID struct {
+	Major, Minor int
+	Inode        uint64
+}
+
+func (id FileID) MajorMinor() string {
+	return fmt.Sprintf("%d:%d", id.Major, id.Minor)
+}
+
 type ProcessInfo struct {
 	PID            int
 	ticksPerSecond int
@@ -20,6 +31,10 @@ type ProcessInfo struct {
 	Stat              []procfs.ProcStat
 	StartSecSinceBoot int
 	FDPath            map[int]string
+	FDFileID          map[int]FileID
+	// FDSince is when each FD was first seen with its current path, the FDs open before procshave started are seen at
+	// its start.
+	FDSince map[int]time.Time
 
 	MainComm   string
 	MainExec   string
@@ -47,9 +62,21 @@ func (proc *ProcessInfo) Refresh() {
 			fdTargets, _ := thread.FileDescriptorTargets()
 			fdNumbers, _ := thread.FileDescriptors()
 			if len(fdTargets) == len(fdNumbers) {
+				prevPath, prevSince := proc.FDPath, proc.FDSince
 				proc.FDPath = make(map[int]string)
+				proc.FDFileID = make(map[int]FileID)
+				proc.FDSince = make(map[int]time.Time)
 				for i, fd := range fdNumbers {
 					proc.FDPath[int(fd)] = fdTargets[i]
+					if since, exists := prevSince[int(fd)]; exists && prevPath[int(fd)] == fdTargets[i] {
+						proc.FDSince[int(fd)] = since
+					} else {
+						proc.FDSince[int(fd)] = time.Now()
+					}
+					var stat unix.Stat_t
+					if err := unix.Stat(fmt.Sprintf("/proc/%d/fd/%d", proc.PID, fd), &stat); err == nil {
+						proc.FDFileID[int(fd)] = FileID{Major: int(unix.Major(stat.Dev)), Minor: int(unix.Minor(stat.Dev)), Inode: stat.Ino}
+					}
 				}
 			}
 		}
@@ -58,8 +85,10 @@ func (proc *ProcessInfo) Refresh() {
 
 func NewProcessInfo(pid int) *ProcessInfo {
 	ret := &ProcessInfo{
-		PID:    pid,
-		FDPath: make(map[int]string),
+		PID:      pid,
+		FDPath:   make(map[int]string),
+		FDFileID: make(map[int]FileID),
+		FDSince:  make(map[int]time.Time),
 	}
 	ticksPerSecond, err := sysconf.Sysconf(sysconf.SC_CLK_TCK)
 	if err != nil {
@@ -79,6 +108,7 @@ type ProcInfo struct {
 	ParentInfo   *ProcessInfo
 	TargetInfo   *ProcessInfo
 	DiskStats    map[string]blockdevice.Diskstats
+	Sockets      map[uint64]*SocketInfo
 
 	Mutex *sync.RWMutex
 }
@@ -109,12 +139,19 @@ func (info *ProcInfo) Refresh() {
 	info.GroupInfo.Refresh()
 	info.SessionInfo.Refresh()
 
+	info.DiskStats = ReadDiskStats()
+	info.Sockets = ReadSockets(info.PID)
+}
+
+// ReadDiskStats returns the block device statistics keyed by "major:minor".
+func ReadDiskStats() map[string]blockdevice.Diskstats {
+	ret := make(map[string]blockdevice.Diskstats)
 	blockdev, _ := blockdevice.NewDefaultFS()
 	diskStats, _ := blockdev.ProcDiskstats()
-	info.DiskStats = make(map[string]blockdevice.Diskstats)
 	for _, disk := range diskStats {
-		info.DiskStats[fmt.Sprintf("%d:%d", disk.MajorNumber, disk.MinorNumber)] = disk
+		ret[fmt.Sprintf("%d:%d", disk.MajorNumber, disk.MinorNumber)] = disk
 	}
+	return ret
 }
 
 func FindPidByComm(comm string) int {
@@ -2,6 +2,10 @@ package main
 
 import (
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -10,48 +14,220 @@ import (
 const (
 	PidLabel      = "pid"
 	HostnameLabel = "hostname"
+	SyscallLabel  = "syscall"
+	SignalLabel   = "signal"
+	DeviceLabel   = "device"
+	ReasonLabel   = "reason"
+	MethodLabel   = "method"
+	RouteLabel    = "route"
+	StatusLabel   = "status"
 )
 
+// BucketHistogramVec is a histogram collector fed with pre-aggregated bucket counts, such as those of a bpftrace hist().
+type BucketHistogramVec struct {
+	mutex  *sync.Mutex
+	desc   *prometheus.Desc
+	labels []string
+	series map[string]*bucketHistogram
+}
+
+type bucketHistogram struct {
+	labelValues []string
+	count       uint64
+	sum         float64
+	buckets     map[float64]uint64
+}
+
+func NewBucketHistogramVec(name, help string, labels []string) *BucketHistogramVec {
+	return &BucketHistogramVec{
+		mutex:  new(sync.Mutex),
+		desc:   prometheus.NewDesc(name, help, labels, nil),
+		labels: labels,
+		series: make(map[string]*bucketHistogram),
+	}
+}
+
+// Observe adds count observations that are no greater than upperBound, and whose values add up to sum.
+func (vec *BucketHistogramVec) Observe(labels prometheus.Labels, upperBound float64, count uint64, sum float64) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	labelValues := make([]string, 0, len(vec.labels))
+	for _, name := range vec.labels {
+		labelValues = append(labelValues, labels[name])
+	}
+	key := strings.Join(labelValues, "\x00")
+	if _, exists := vec.series[key]; !exists {
+		vec.series[key] = &bucketHistogram{labelValues: labelValues, buckets: make(map[float64]uint64)}
+	}
+	hist := vec.series[key]
+	hist.count += count
+	hist.sum += sum
+	hist.buckets[upperBound] += count
+}
+
+func (vec *BucketHistogramVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- vec.desc
+}
+
+func (vec *BucketHistogramVec) Collect(ch chan<- prometheus.Metric) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	for _, hist := range vec.series {
+		var upperBounds []float64
+		for upperBound := range hist.buckets {
+			upperBounds = append(upperBounds, upperBound)
+		}
+		sort.Float64s(upperBounds)
+		cumulative := make(map[float64]uint64)
+		var count uint64
+		for _, upperBound := range upperBounds {
+			count += hist.buckets[upperBound]
+			cumulative[upperBound] = count
+		}
+		ch <- prometheus.MustNewConstHistogram(vec.desc, hist.count, hist.sum, cumulative, hist.labelValues...)
+	}
+}
+
 type MetricsCollector struct {
-	TcpSourceEndpointsCount      *prometheus.GaugeVec
-	TcpSourceTrafficBytes        *prometheus.GaugeVec
-	TcpDestinationEndpointsCount *prometheus.GaugeVec
-	TcpDestinationTrafficBytes   *prometheus.GaugeVec
-	ReadFromFDCount              *prometheus.GaugeVec
-	WrittenToFDCount             *prometheus.GaugeVec
-	ReadFromFDBytes              *prometheus.GaugeVec
-	WrittenToFDBytes             *prometheus.GaugeVec
-	BlockIOSectors               *prometheus.GaugeVec
-	BlockIOTimeMillis            *prometheus.GaugeVec
+	TcpSourceEndpoints         *prometheus.GaugeVec
+	TcpSourceTrafficBytes      *prometheus.CounterVec
+	TcpDestinationEndpoints    *prometheus.GaugeVec
+	TcpDestinationTrafficBytes *prometheus.CounterVec
+	SocketSendQueueBytes       *prometheus.GaugeVec
+	SocketRecvQueueBytes       *prometheus.GaugeVec
+	SkbDrops                   *prometheus.CounterVec
+	ListenOverflows            *prometheus.CounterVec
+	AcceptFirstByteSeconds     *BucketHistogramVec
+	TLSReadBytes               *prometheus.CounterVec
+	TLSWrittenBytes            *prometheus.CounterVec
+	HTTPRequestsTotal          *prometheus.CounterVec
+	HTTPRequestDuration        *prometheus.HistogramVec
+	ReadFDs                    *prometheus.GaugeVec
+	WrittenFDs                 *prometheus.GaugeVec
+	FDReadBytes                *prometheus.CounterVec
+	FDWrittenBytes             *prometheus.CounterVec
+	FDLatencySeconds           *BucketHistogramVec
+	BlockIOBytes               *prometheus.CounterVec
+	BlockIOOps                 *prometheus.CounterVec
+	BlockIOQueueSeconds        *prometheus.CounterVec
+	BlockIOServiceSeconds      *BucketHistogramVec
+	DirtiedBytes               *prometheus.CounterVec
+	WrittenBackBytes           *prometheus.CounterVec
+	DirtyThrottleSeconds       *prometheus.CounterVec
+	Syscalls                   *prometheus.CounterVec
+	SyscallErrors              *prometheus.CounterVec
+	SyscallSeconds             *prometheus.CounterVec
+	SignalsTotal               *prometheus.CounterVec
+	FutexWaits                 *prometheus.CounterVec
+	FutexWaitSeconds           *prometheus.CounterVec
+	GoMutexContentions         *prometheus.CounterVec
+	FileReadBytes              *prometheus.CounterVec
+	FileWrittenBytes           *prometheus.CounterVec
+	SocketReadBytes            *prometheus.CounterVec
+	SocketWrittenBytes         *prometheus.CounterVec
+	BlockDeviceIOBytes         *prometheus.CounterVec
+	BlockDeviceIOOps           *prometheus.CounterVec
+
+	// SeriesLimit is nil unless the series labelled by path, remote endpoint, or device are wanted.
+	SeriesLimit *SeriesLimit
+	// API is served along with the metrics if it is not nil.
+	API *APIServer
+	// Security protects the server, all endpoints are served without authentication if it is nil.
+	Security *ListenerSecurity
+}
+
+func newGaugeVec(name, help string, labels ...string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)
 }
 
-func NewMetricsCollector() *MetricsCollector {
-	labels := []string{PidLabel, HostnameLabel}
+func newCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+}
+
+// NewMetricsCollector registers the metrics with the identity labels of the target process added to each of them.
+func NewMetricsCollector(identity prometheus.Labels) *MetricsCollector {
 	ret := &MetricsCollector{
-		TcpSourceEndpointsCount:      prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_tcp_src_endpoint_count"}, labels),
-		TcpSourceTrafficBytes:        prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_tcp_src_traffic_bytes"}, labels),
-		TcpDestinationEndpointsCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_tcp_dest_endpoint_count"}, labels),
-		TcpDestinationTrafficBytes:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_tcp_dest_traffic_bytes"}, labels),
-		ReadFromFDCount:              prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_fd_in_read_count"}, labels),
-		WrittenToFDCount:             prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_fd_in_write_count"}, labels),
-		ReadFromFDBytes:              prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_fd_read_bytes"}, labels),
-		WrittenToFDBytes:             prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_fd_written_bytes"}, labels),
-		BlockIOSectors:               prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_block_io_sector_count"}, labels),
-		BlockIOTimeMillis:            prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "procshave_block_io_duration_millis"}, labels),
+		TcpSourceEndpoints:         newGaugeVec("procshave_tcp_src_endpoints", "Distinct TCP source endpoints of the target's traffic in the latest sampling interval."),
+		TcpSourceTrafficBytes:      newCounterVec("procshave_tcp_src_traffic_bytes_total", "TCP payload bytes of the target, counted by the source endpoint."),
+		TcpDestinationEndpoints:    newGaugeVec("procshave_tcp_dest_endpoints", "Distinct TCP destination endpoints of the target's traffic in the latest sampling interval."),
+		TcpDestinationTrafficBytes: newCounterVec("procshave_tcp_dest_traffic_bytes_total", "TCP payload bytes of the target, counted by the destination endpoint."),
+		SocketSendQueueBytes:       newGaugeVec("procshave_socket_send_queue_bytes", "Bytes waiting in the send queues of the target's sockets."),
+		SocketRecvQueueBytes:       newGaugeVec("procshave_socket_recv_queue_bytes", "Bytes waiting in the receive queues of the target's sockets."),
+		SkbDrops:                   newCounterVec("procshave_skb_drops_total", "Packets of the target's sockets dropped by the kernel.", ReasonLabel),
+		ListenOverflows:            newCounterVec("procshave_listen_overflows_total", "Connections dropped as the accept queue of a listening socket was full."),
+		AcceptFirstByteSeconds:     NewBucketHistogramVec("procshave_accept_first_byte_seconds", "Time from accepting a connection to receiving its first byte.", nil),
+		TLSReadBytes:               newCounterVec("procshave_tls_read_bytes_total", "Plaintext bytes read from TLS connections."),
+		TLSWrittenBytes:            newCounterVec("procshave_tls_written_bytes_total", "Plaintext bytes written to TLS connections."),
+		HTTPRequestsTotal:          newCounterVec("procshave_http_requests_total", "HTTP requests observed in the socket payloads of the target.", MethodLabel, RouteLabel, StatusLabel),
+		HTTPRequestDuration:        prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "procshave_http_request_duration_seconds", Help: "Time from an HTTP request to its response.", Buckets: HTTPLatencyBuckets}, []string{MethodLabel, RouteLabel}),
+		ReadFDs:                    newGaugeVec("procshave_read_fds", "File descriptors read from in the latest sampling interval."),
+		WrittenFDs:                 newGaugeVec("procshave_written_fds", "File descriptors written to in the latest sampling interval."),
+		FDReadBytes:                newCounterVec("procshave_fd_read_bytes_total", "Bytes read from file descriptors."),
+		FDWrittenBytes:             newCounterVec("procshave_fd_written_bytes_total", "Bytes written to file descriptors."),
+		FDLatencySeconds:           NewBucketHistogramVec("procshave_fd_io_latency_seconds", "Latency of the read and write syscalls made by the target.", nil),
+		BlockIOBytes:               newCounterVec("procshave_block_io_bytes_total", "Bytes transferred by the block IO requests of the target."),
+		BlockIOOps:                 newCounterVec("procshave_block_io_ops_total", "Block IO requests of the target."),
+		BlockIOQueueSeconds:        newCounterVec("procshave_block_io_queue_seconds_total", "Time the target's block IO requests spent in the IO scheduler queue."),
+		BlockIOServiceSeconds:      NewBucketHistogramVec("procshave_block_io_service_seconds", "Block device service time from issue to completion of the target's requests.", []string{DeviceLabel}),
+		DirtiedBytes:               newCounterVec("procshave_dirtied_bytes_total", "Page cache bytes dirtied by the target."),
+		WrittenBackBytes:           newCounterVec("procshave_written_back_bytes_total", "Bytes of the target's dirty pages written back to block devices."),
+		DirtyThrottleSeconds:       newCounterVec("procshave_dirty_throttle_seconds_total", "Time the target was throttled for dirtying pages too quickly."),
+		Syscalls:                   newCounterVec("procshave_syscalls_total", "Syscalls made by the target.", SyscallLabel),
+		SyscallErrors:              newCounterVec("procshave_syscall_errors_total", "Syscalls made by the target that returned an error.", SyscallLabel),
+		SyscallSeconds:             newCounterVec("procshave_syscall_seconds_total", "Time spent in the syscalls made by the target.", SyscallLabel),
+		SignalsTotal:               newCounterVec("procshave_signals_total", "Signals sent to the target process and its children.", SignalLabel),
+		FutexWaits:                 newCounterVec("procshave_futex_waits_total", "Futex waits of the target's threads."),
+		FutexWaitSeconds:           newCounterVec("procshave_futex_wait_seconds_total", "Time the target's threads spent waiting on futexes."),
+		GoMutexContentions:         newCounterVec("procshave_go_mutex_contentions_total", "Calls to the slow path of sync.Mutex in a Go target, which happen when the mutex is already locked."),
+		FileReadBytes:              newCounterVec("procshave_file_read_bytes_total", "Bytes read from each file by read syscalls.", PathLabel),
+		FileWrittenBytes:           newCounterVec("procshave_file_written_bytes_total", "Bytes written to each file by write syscalls.", PathLabel),
+		SocketReadBytes:            newCounterVec("procshave_socket_read_bytes_total", "Bytes read from the socket of each remote endpoint by read syscalls.", RemoteAddrLabel, RemotePortLabel),
+		SocketWrittenBytes:         newCounterVec("procshave_socket_written_bytes_total", "Bytes written to the socket of each remote endpoint by write syscalls.", RemoteAddrLabel, RemotePortLabel),
+		BlockDeviceIOBytes:         newCounterVec("procshave_block_device_io_bytes_total", "Bytes transferred by the block IO requests of the target on each device.", DeviceLabel),
+		BlockDeviceIOOps:           newCounterVec("procshave_block_device_io_ops_total", "Block IO requests of the target on each device.", DeviceLabel),
 	}
+	registerer := prometheus.WrapRegistererWith(identity, prometheus.DefaultRegisterer)
 	for _, metric := range []prometheus.Collector{
-		ret.TcpSourceEndpointsCount,
+		ret.TcpSourceEndpoints,
 		ret.TcpSourceTrafficBytes,
-		ret.TcpDestinationEndpointsCount,
+		ret.TcpDestinationEndpoints,
 		ret.TcpDestinationTrafficBytes,
-		ret.ReadFromFDCount,
-		ret.WrittenToFDCount,
-		ret.ReadFromFDBytes,
-		ret.WrittenToFDBytes,
-		ret.BlockIOSectors,
-		ret.BlockIOTimeMillis,
+		ret.SocketSendQueueBytes,
+		ret.SocketRecvQueueBytes,
+		ret.SkbDrops,
+		ret.ListenOverflows,
+		ret.AcceptFirstByteSeconds,
+		ret.TLSReadBytes,
+		ret.TLSWrittenBytes,
+		ret.HTTPRequestsTotal,
+		ret.HTTPRequestDuration,
+		ret.ReadFDs,
+		ret.WrittenFDs,
+		ret.FDReadBytes,
+		ret.FDWrittenBytes,
+		ret.FDLatencySeconds,
+		ret.BlockIOBytes,
+		ret.BlockIOOps,
+		ret.BlockIOQueueSeconds,
+		ret.BlockIOServiceSeconds,
+		ret.DirtiedBytes,
+		ret.WrittenBackBytes,
+		ret.DirtyThrottleSeconds,
+		ret.Syscalls,
+		ret.SyscallErrors,
+		ret.SyscallSeconds,
+		ret.SignalsTotal,
+		ret.FutexWaits,
+		ret.FutexWaitSeconds,
+		ret.GoMutexContentions,
+		ret.FileReadBytes,
+		ret.FileWrittenBytes,
+		ret.SocketReadBytes,
+		ret.SocketWrittenBytes,
+		ret.BlockDeviceIOBytes,
+		ret.BlockDeviceIOOps,
 	} {
-		if err := prometheus.Register(metric); err != nil {
+		if err := registerer.Register(metric); err != nil {
 			panic(err)
 		}
 	}
@@ -62,5 +238,20 @@ func (metrics *MetricsCollector) Start(address string) error {
 	mux := http.NewServeMux()
 	handler := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{}))
 	mux.Handle("/procshave-metrics", handler)
-	return http.ListenAndServe(address, mux)
+	if metrics.API != nil {
+		metrics.API.Register(mux)
+	}
+	security := metrics.Security
+	if security == nil {
+		security = &ListenerSecurity{Endpoints: map[string]bool{EndpointMetrics: true, EndpointAPI: true, EndpointDashboard: true}}
+	}
+	tlsConfig, err := security.TLSConfig()
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: address, Handler: security.Handler(mux), TLSConfig: tlsConfig, ReadHeaderTimeout: 10 * time.Second}
+	if tlsConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	MaxPushAttempts    = 5
+	InitialPushBackoff = 500 * time.Millisecond
+	PushTimeout        = 10 * time.Second
+	JobLabel           = "job"
+	InstanceLabel      = "instance"
+)
+
+// MetricsPusher pushes the metrics of the default registry to a Pushgateway and to a Prometheus remote_write endpoint,
+// for the hosts that cannot be scraped and for the processes that are gone before the next scrape.
+type MetricsPusher struct {
+	// PushgatewayURL and RemoteWriteURL are left empty to not push there.
+	PushgatewayURL string
+	RemoteWriteURL string
+	Job            string
+	// Instance tells apart the processes pushing to the same Pushgateway job.
+	Instance string
+
+	client *http.Client
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewMetricsPusher derives the Pushgateway instance from the identity labels, which are already on each of the metrics
+// and therefore cannot be the grouping labels themselves.
+func NewMetricsPusher(pushgatewayURL, remoteWriteURL, job string, identity prometheus.Labels) *MetricsPusher {
+	var instance []string
+	for name, value := range identity {
+		instance = append(instance, name+"="+value)
+	}
+	sort.Strings(instance)
+	return &MetricsPusher{
+		PushgatewayURL: pushgatewayURL,
+		RemoteWriteURL: remoteWriteURL,
+		Job:            job,
+		Instance:       strings.Join(instance, ","),
+		client:         &http.Client{Timeout: PushTimeout},
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+// Start pushes the metrics at each interval until Stop.
+func (pusher *MetricsPusher) Start(interval time.Duration) {
+	go func() {
+		defer close(pusher.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pusher.PushAll()
+			case <-pusher.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the ongoing push, and then pushes the final values of the metrics.
+func (pusher *MetricsPusher) Stop() {
+	close(pusher.stop)
+	<-pusher.done
+	pusher.PushAll()
+}
+
+// PushAll pushes the metrics to each of the configured endpoints, retrying the failed pushes with exponential backoff.
+func (pusher *MetricsPusher) PushAll() {
+	if pusher.PushgatewayURL != "" {
+		if err := retryPush(pusher.pushToGateway); err != nil {
+			log.Printf("Failed to push metrics to the Pushgateway: %v", err)
+		}
+	}
+	if pusher.RemoteWriteURL != "" {
+		if err := retryPush(pusher.remoteWrite); err != nil {
+			log.Printf("Failed to push metrics to the remote_write endpoint: %v", err)
+		}
+	}
+}
+
+func retryPush(pushFunc func() error) error {
+	backoff := InitialPushBackoff
+	var err error
+	for attempt := 1; attempt <= MaxPushAttempts; attempt++ {
+		if err = pushFunc(); err == nil {
+			return nil
+		}
+		if _, permanent := err.(permanentPushError); permanent {
+			return err
+		}
+		if attempt < MaxPushAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", MaxPushAttempts, err)
+}
+
+// permanentPushError is an error of a push that will not succeed by retrying, such as a malformed request.
+type permanentPushError struct {
+	error
+}
+
+func (pusher *MetricsPusher) pushToGateway() error {
+	// A push.Pusher remembers its first error, a new one is needed for each attempt.
+	gateway := push.New(pusher.PushgatewayURL, pusher.Job).Client(pusher.client).Gatherer(prometheus.DefaultGatherer)
+	if pusher.Instance != "" {
+		gateway = gateway.Grouping(InstanceLabel, pusher.Instance)
+	}
+	return gateway.Push()
+}
+
+func (pusher *MetricsPusher) remoteWrite() error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, EncodeRemoteWrite(families, prometheus.Labels{JobLabel: pusher.Job}, time.Now()))
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, pusher.RemoteWriteURL, bytes.NewReader(body))
+	if err != nil {
+		return permanentPushError{err}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "procshave")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := pusher.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote_write endpoint responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	// The client errors other than rate limiting are not retried, according to the remote_write specification.
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentPushError{err}
+	}
+	return err
+}
+
+// EncodeRemoteWrite encodes the metric families into a remote_write WriteRequest protobuf message, with the extra
+// labels added to each time series.
+func EncodeRemoteWrite(families []*dto.MetricFamily, extra prometheus.Labels, now time.Time) []byte {
+	/*
+		The message is small enough to be encoded by hand instead of depending on the Prometheus server module:
+		message WriteRequest { repeated TimeSeries timeseries = 1; }
+		message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+		message Label { string name = 1; string value = 2; }
+		message Sample { double value = 1; int64 timestamp = 2; }
+	*/
+	var ret []byte
+	timestamp := now.UnixMilli()
+	appendSeries := func(name string, metric *dto.Metric, value float64, labelName, labelValue string) {
+		labels := map[string]string{"__name__": name}
+		for labelName, labelValue := range extra {
+			labels[labelName] = labelValue
+		}
+		for _, pair := range metric.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		if labelName != "" {
+			labels[labelName] = labelValue
+		}
+		var sortedNames []string
+		for labelName := range labels {
+			sortedNames = append(sortedNames, labelName)
+		}
+		// The labels of a time series must be sorted by name.
+		sort.Strings(sortedNames)
+		var series []byte
+		for _, labelName := range sortedNames {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, labelName)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, labels[labelName])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+		ret = protowire.AppendTag(ret, 1, protowire.BytesType)
+		ret = protowire.AppendBytes(ret, series)
+	}
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.GetMetric() {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				appendSeries(name, metric, metric.GetCounter().GetValue(), "", "")
+			case dto.MetricType_GAUGE:
+				appendSeries(name, metric, metric.GetGauge().GetValue(), "", "")
+			case dto.MetricType_UNTYPED:
+				appendSeries(name, metric, metric.GetUntyped().GetValue(), "", "")
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					appendSeries(name, metric, quantile.GetValue(), "quantile", strconv.FormatFloat(quantile.GetQuantile(), 'g', -1, 64))
+				}
+				appendSeries(name+"_sum", metric, summary.GetSampleSum(), "", "")
+				appendSeries(name+"_count", metric, float64(summary.GetSampleCount()), "", "")
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					if math.IsInf(bucket.GetUpperBound(), 1) {
+						continue
+					}
+					appendSeries(name+"_bucket", metric, float64(bucket.GetCumulativeCount()), "le", strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64))
+				}
+				appendSeries(name+"_bucket", metric, float64(histogram.GetSampleCount()), "le", "+Inf")
+				appendSeries(name+"_sum", metric, histogram.GetSampleSum(), "", "")
+				appendSeries(name+"_count", metric, float64(histogram.GetSampleCount()), "", "")
+			}
+		}
+	}
+	return ret
+}
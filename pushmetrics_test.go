@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteSeries is a decoded remote_write TimeSeries of a single sample.
+type remoteWriteSeries struct {
+	LabelNames []string
+	Labels     map[string]string
+	Value      float64
+	Timestamp  int64
+}
+
+// decodeRemoteWrite decodes a WriteRequest independently of EncodeRemoteWrite, field by field.
+func decodeRemoteWrite(t *testing.T, message []byte) []remoteWriteSeries {
+	t.Helper()
+	var ret []remoteWriteSeries
+	forEachField(t, message, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if num != 1 || typ != protowire.BytesType {
+			t.Fatalf("unexpected WriteRequest field %d of type %d", num, typ)
+		}
+		series := remoteWriteSeries{Labels: make(map[string]string)}
+		var samples int
+		forEachField(t, value, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+			switch num {
+			case 1:
+				var name, labelValue string
+				forEachField(t, value, func(num protowire.Number, _ protowire.Type, value []byte, _ uint64) {
+					if num == 1 {
+						name = string(value)
+					} else if num == 2 {
+						labelValue = string(value)
+					}
+				})
+				series.LabelNames = append(series.LabelNames, name)
+				series.Labels[name] = labelValue
+			case 2:
+				samples++
+				forEachField(t, value, func(num protowire.Number, typ protowire.Type, _ []byte, number uint64) {
+					if num == 1 && typ == protowire.Fixed64Type {
+						series.Value = math.Float64frombits(number)
+					} else if num == 2 && typ == protowire.VarintType {
+						series.Timestamp = int64(number)
+					} else {
+						t.Fatalf("unexpected Sample field %d of type %d", num, typ)
+					}
+				})
+			default:
+				t.Fatalf("unexpected TimeSeries field %d", num)
+			}
+		})
+		if samples != 1 {
+			t.Fatalf("got %d samples in series %v, want 1", samples, series.Labels)
+		}
+		ret = append(ret, series)
+	})
+	return ret
+}
+
+// forEachField calls fn with the bytes of each length-delimited field, or the number of each varint and fixed field.
+func forEachField(t *testing.T, message []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, number uint64)) {
+	t.Helper()
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			t.Fatalf("malformed tag: %v", protowire.ParseError(n))
+		}
+		message = message[n:]
+		switch typ {
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(message)
+			if n < 0 {
+				t.Fatalf("malformed field %d: %v", num, protowire.ParseError(n))
+			}
+			fn(num, typ, value, 0)
+			message = message[n:]
+		case protowire.VarintType:
+			number, n := protowire.ConsumeVarint(message)
+			if n < 0 {
+				t.Fatalf("malformed field %d: %v", num, protowire.ParseError(n))
+			}
+			fn(num, typ, nil, number)
+			message = message[n:]
+		case protowire.Fixed64Type:
+			number, n := protowire.ConsumeFixed64(message)
+			if n < 0 {
+				t.Fatalf("malformed field %d: %v", num, protowire.ParseError(n))
+			}
+			fn(num, typ, nil, number)
+			message = message[n:]
+		default:
+			t.Fatalf("unexpected wire type %d of field %d", typ, num)
+		}
+	}
+}
+
+func TestEncodeRemoteWrite(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_bytes_total", Help: "Test."}, []string{"zone", "app"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "Test.", Buckets: []float64{0.1, 1}})
+	registry.MustRegister(counter, histogram)
+	counter.WithLabelValues("b", "web").Add(42)
+	for _, seconds := range []float64{0.05, 0.5, 0.7, 3} {
+		histogram.Observe(seconds)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.UnixMilli(1700000000123)
+	series := decodeRemoteWrite(t, EncodeRemoteWrite(families, prometheus.Labels{JobLabel: "batch"}, now))
+
+	byName := make(map[string][]remoteWriteSeries)
+	for _, s := range series {
+		if !slices.IsSorted(s.LabelNames) || len(s.LabelNames) != len(s.Labels) {
+			t.Errorf("labels are not sorted or repeat: %v", s.LabelNames)
+		}
+		if s.Timestamp != now.UnixMilli() {
+			t.Errorf("series %v has timestamp %d, want %d", s.Labels, s.Timestamp, now.UnixMilli())
+		}
+		if s.Labels[JobLabel] != "batch" {
+			t.Errorf("series %v lacks the extra label", s.Labels)
+		}
+		byName[s.Labels["__name__"]] = append(byName[s.Labels["__name__"]], s)
+	}
+	if got := byName["test_bytes_total"]; len(got) != 1 || got[0].Value != 42 ||
+		strings.Join(got[0].LabelNames, ",") != "__name__,app,job,zone" || got[0].Labels["app"] != "web" || got[0].Labels["zone"] != "b" {
+		t.Errorf("unexpected counter series: %+v", got)
+	}
+	buckets := make(map[string]float64)
+	for _, s := range byName["test_seconds_bucket"] {
+		if _, exists := buckets[s.Labels["le"]]; exists {
+			t.Errorf("bucket le=%q is repeated", s.Labels["le"])
+		}
+		buckets[s.Labels["le"]] = s.Value
+	}
+	if len(buckets) != 3 || buckets["0.1"] != 1 || buckets["1"] != 3 || buckets["+Inf"] != 4 {
+		t.Errorf("unexpected histogram buckets: %v", buckets)
+	}
+	if got := byName["test_seconds_count"]; len(got) != 1 || got[0].Value != 4 {
+		t.Errorf("unexpected histogram count: %+v", got)
+	}
+	if got := byName["test_seconds_sum"]; len(got) != 1 || math.Abs(got[0].Value-4.25) > 1e-9 {
+		t.Errorf("unexpected histogram sum: %+v", got)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type RequestModel struct {
+	PID    int
+	BPF    *BpfTracer
+	Proc   *ProcInfo
+	Width  int
+	Height int
+	Cursor RowCursor
+	Sort   SortOrder
+	Filter RowFilter
+}
+
+const (
+	RequestSortCount   = "count"
+	RequestSortLatency = "latency"
+	RequestSortRoute   = "route"
+)
+
+func NewRequestModel(pid int, procInfo *ProcInfo, bpf *BpfTracer) *RequestModel {
+	return &RequestModel{PID: pid, Proc: procInfo, BPF: bpf, Sort: NewSortOrder(RequestSortCount, RequestSortLatency, RequestSortRoute)}
+}
+
+func (model *RequestModel) Init() tea.Cmd {
+	return nil
+}
+
+func (model *RequestModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		model.Cursor.Update(msg)
+		model.Sort.Update(msg)
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
+	}
+	return model, nil
+}
+
+func (model *RequestModel) GetRegularStyle() lipgloss.Style {
+	return lipgloss.NewStyle().
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
+		BorderStyle(lipgloss.RoundedBorder())
+}
+
+func (model *RequestModel) GetFocusedStyle() lipgloss.Style {
+	return lipgloss.NewStyle().Inherit(model.GetRegularStyle()).
+		BorderForeground(lipgloss.Color(FocusedBorderForeground)).
+		BorderBackground(lipgloss.Color(FocusedBorderBackground))
+}
+
+func (model *RequestModel) GetFilter() *RowFilter {
+	return &model.Filter
+}
+
+func (model *RequestModel) View() string {
+	ret := ListHeader("Requests - HTTP", model.Sort, model.Cursor, model.Filter) + "\n"
+	if !model.BPF.HTTPEnabled {
+		ret += "Start procshave with -http to measure the requests in plaintext HTTP/1 traffic."
+		return ret
+	}
+	requests := model.sortedRequests()
+	var rowNames []string
+	for _, counter := range requests {
+		rowNames = append(rowNames, counter.Method+" "+counter.Route)
+	}
+	from, to := model.Cursor.SetRows(rowNames, model.Height-3)
+	if len(requests) == 0 {
+		ret += "No data yet."
+		return ret
+	}
+	for i := from; i < to; i++ {
+		counter := requests[i]
+		ret += model.Cursor.Render(i, fmt.Sprintf("%-7s %-24s %-7s avg %-8s %s",
+			counter.Method,
+			PathCaption(counter.Route, 24),
+			fmt.Sprintf("%d/s", counter.Count/model.BPF.SamplingIntervalSec),
+			(counter.Duration/time.Duration(max(counter.Count, 1))).Round(time.Microsecond),
+			TopCountsCaption(counter.Statuses, 2))) + "\n"
+	}
+	return ret
+}
+
+func (model *RequestModel) sortedRequests() []*HTTPRequestCounter {
+	requests := model.BPF.HTTPSummary().ByCount
+	sort.SliceStable(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		switch model.Sort.Column() {
+		case RequestSortLatency:
+			return a.Duration/time.Duration(max(a.Count, 1)) > b.Duration/time.Duration(max(b.Count, 1))
+		case RequestSortRoute:
+			return a.Route < b.Route || (a.Route == b.Route && a.Method < b.Method)
+		default:
+			return a.Count > b.Count
+		}
+	})
+	return slices.DeleteFunc(requests, func(counter *HTTPRequestCounter) bool {
+		return !model.Filter.Match(counter.Method+" "+counter.Route, nil)
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	EndpointMetrics   = "metrics"
+	EndpointAPI       = "api"
+	EndpointDashboard = "dashboard"
+)
+
+// ListenerSecurity protects the metrics, API, and dashboard server.
+type ListenerSecurity struct {
+	// CertFile and KeyFile enable TLS, ClientCAFile additionally requires the clients to present a certificate
+	// signed by one of its CAs.
+	CertFile, KeyFile, ClientCAFile string
+	// BasicAuth maps user names to the SHA-256 of their passwords, and BearerTokens holds the SHA-256 of the tokens.
+	// The requests are let through without authentication if both are empty.
+	BasicAuth    map[string][32]byte
+	BearerTokens [][32]byte
+	// Endpoints is the allow-list of the endpoints served, among metrics, api, and dashboard.
+	Endpoints map[string]bool
+}
+
+// NewListenerSecurity reads the user:password lines of basicAuthFile and the token lines of bearerTokenFile, either
+// of the file names may be empty.
+func NewListenerSecurity(certFile, keyFile, clientCAFile, basicAuthFile, bearerTokenFile, endpoints string) (*ListenerSecurity, error) {
+	ret := &ListenerSecurity{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: clientCAFile,
+		BasicAuth:    make(map[string][32]byte),
+		Endpoints:    make(map[string]bool),
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("the TLS certificate and key must be given together")
+	}
+	if clientCAFile != "" && certFile == "" {
+		return nil, errors.New("client certificate verification requires a TLS certificate and key")
+	}
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		switch endpoint {
+		case "":
+			continue
+		case EndpointMetrics, EndpointAPI, EndpointDashboard:
+			ret.Endpoints[endpoint] = true
+		default:
+			return nil, fmt.Errorf("unknown endpoint %q, expecting %s, %s, or %s", endpoint, EndpointMetrics, EndpointAPI, EndpointDashboard)
+		}
+	}
+	// The dashboard is of no use without the API it renders.
+	if ret.Endpoints[EndpointDashboard] && !ret.Endpoints[EndpointAPI] {
+		return nil, fmt.Errorf("the %s endpoint requires the %s endpoint", EndpointDashboard, EndpointAPI)
+	}
+	if basicAuthFile != "" {
+		lines, err := readSecretLines(basicAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			user, password, found := strings.Cut(line, ":")
+			if !found || user == "" || password == "" {
+				return nil, fmt.Errorf("malformed line in %s, expecting user:password", basicAuthFile)
+			}
+			ret.BasicAuth[user] = sha256.Sum256([]byte(password))
+		}
+	}
+	if bearerTokenFile != "" {
+		lines, err := readSecretLines(bearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			ret.BearerTokens = append(ret.BearerTokens, sha256.Sum256([]byte(line)))
+		}
+	}
+	return ret, nil
+}
+
+// readSecretLines returns the non-empty lines of the file that are not comments.
+func readSecretLines(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var ret []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			ret = append(ret, line)
+		}
+	}
+	if len(ret) == 0 && scanner.Err() == nil {
+		return nil, fmt.Errorf("%s has no entries", fileName)
+	}
+	return ret, scanner.Err()
+}
+
+func (security *ListenerSecurity) RequiresAuth() bool {
+	return len(security.BasicAuth) > 0 || len(security.BearerTokens) > 0
+}
+
+// TLSConfig returns nil if TLS is not enabled.
+func (security *ListenerSecurity) TLSConfig() (*tls.Config, error) {
+	if security.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(security.CertFile, security.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	ret := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if security.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(security.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		ret.ClientCAs = x509.NewCertPool()
+		if !ret.ClientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("%s has no PEM encoded certificates", security.ClientCAFile)
+		}
+		ret.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return ret, nil
+}
+
+// endpointOf tells the allow-list endpoint a request path belongs to.
+func endpointOf(path string) string {
+	switch {
+	case path == "/procshave-metrics":
+		return EndpointMetrics
+	case strings.HasPrefix(path, "/api/"):
+		return EndpointAPI
+	default:
+		return EndpointDashboard
+	}
+}
+
+// Handler lets the authenticated, read-only requests of the allowed endpoints through to the handler. The endpoints
+// are all read-only for now, an endpoint that changes the state of procshave will need its own permission.
+func (security *ListenerSecurity) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !security.Endpoints[endpointOf(r.URL.Path)] {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "the endpoints are read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		if security.RequiresAuth() && !security.authenticated(r) {
+			if len(security.BasicAuth) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="procshave", charset="UTF-8"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="procshave"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (security *ListenerSecurity) authenticated(r *http.Request) bool {
+	if user, password, ok := r.BasicAuth(); ok {
+		// Compare the hashes in constant time so that the response time does not reveal the password.
+		expected, exists := security.BasicAuth[user]
+		actual := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(expected[:], actual[:]) == 1 && exists
+	}
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		actual := sha256.Sum256([]byte(strings.TrimSpace(token)))
+		var matched int
+		for _, expected := range security.BearerTokens {
+			matched |= subtle.ConstantTimeCompare(expected[:], actual[:])
+		}
+		return matched == 1
+	}
+	return false
+}
+
+// IsLoopbackAddress tells whether the host of a host:port listener address only accepts local connections.
+func IsLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type SyscallModel struct {
+	PID     int
+	BPF     *BpfTracer
+	Proc    *ProcInfo
+	History *History
+	Width   int
+	Height  int
+	Cursor  RowCursor
+	Sort    SortOrder
+	Filter  RowFilter
+}
+
+const (
+	SyscallSortCount  = "count"
+	SyscallSortTime   = "time"
+	SyscallSortErrors = "errors"
+)
+
+func NewSyscallModel(pid int, procInfo *ProcInfo, bpf *BpfTracer, history *History) *SyscallModel {
+	return &SyscallModel{PID: pid, Proc: procInfo, BPF: bpf, History: history, Sort: NewSortOrder(SyscallSortCount, SyscallSortTime, SyscallSortErrors)}
+}
+
+func (model *SyscallModel) Init() tea.Cmd {
+	return nil
+}
+
+func (model *SyscallModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		model.Cursor.Update(msg)
+		model.Sort.Update(msg)
+	case PanelSizeMessage:
+		model.Width, model.Height = msg.Width, msg.Height
+	}
+	return model, nil
+}
+
+func (model *SyscallModel) GetRegularStyle() lipgloss.Style {
+	return lipgloss.NewStyle().
+		Width(model.Width-2).Height(model.Height-2).Align(lipgloss.Left, lipgloss.Top).
+		BorderStyle(lipgloss.RoundedBorder())
+}
+
+func (model *SyscallModel) GetFocusedStyle() lipgloss.Style {
+	return lipgloss.NewStyle().Inherit(model.GetRegularStyle()).
+		BorderForeground(lipgloss.Color(FocusedBorderForeground)).
+		BorderBackground(lipgloss.Color(FocusedBorderBackground))
+}
+
+func (model *SyscallModel) GetFilter() *RowFilter {
+	return &model.Filter
+}
+
+func TopCountsCaption(errors map[string]int, maxCounts int) string {
+	type errnoCount struct {
+		name  string
+		count int
+	}
+	var counts []errnoCount
+	for name, count := range errors {
+		counts = append(counts, errnoCount{name, count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].count > counts[j].count
+	})
+	var ret string
+	for i, count := range counts {
+		if i == maxCounts {
+			break
+		}
+		ret += fmt.Sprintf("%s:%d ", count.name, count.count)
+	}
+	return ret
+}
+
+func (model *SyscallModel) View() string {
+	syscalls := model.sortedSyscalls()
+	var rowNames []string
+	for _, syscall := range syscalls {
+		rowNames = append(rowNames, syscall.Name)
+	}
+	from, to := model.Cursor.SetRows(rowNames, model.Height-3)
+	ret := ListHeader("Syscalls", model.Sort, model.Cursor, model.Filter) + "\n"
+	if len(syscalls) == 0 {
+		ret += "No data yet."
+		return ret
+	}
+	for i := from; i < to; i++ {
+		syscall := syscalls[i]
+		ret += model.Cursor.Render(i, fmt.Sprintf("%-18s %s %-7s %-10s avg %-8s %s",
+			PathCaption(syscall.Name, 18),
+			model.History.Sparkline(HistoryKey(HistorySyscall, syscall.Name), SparklineWidth(model.Width, 68)),
+			fmt.Sprintf("%d/s", syscall.Count/model.BPF.SamplingIntervalSec),
+			(syscall.Duration/time.Duration(model.BPF.SamplingIntervalSec)).Round(time.Microsecond).String()+"/s",
+			(syscall.Duration/time.Duration(max(syscall.Count, 1))).Round(time.Microsecond),
+			TopCountsCaption(syscall.Errors, 2))) + "\n"
+	}
+	return ret
+}
+
+func (model *SyscallModel) sortedSyscalls() []*SyscallCounter {
+	summary := model.BPF.SyscallSummary()
+	syscalls := summary.ByCount
+	switch model.Sort.Column() {
+	case SyscallSortTime:
+		syscalls = summary.ByDuration
+	case SyscallSortErrors:
+		sort.SliceStable(syscalls, func(i, j int) bool {
+			return syscalls[i].ErrorCount > syscalls[j].ErrorCount
+		})
+	}
+	return slices.DeleteFunc(syscalls, func(syscall *SyscallCounter) bool {
+		return !model.Filter.Match(syscall.Name, nil)
+	})
+}
+
+func (model *SyscallModel) ChartSeries() []ChartSeries {
+	return []ChartSeries{
+		{Title: "Syscalls per second", Key: HistoryKey(HistoryTotal, TotalSyscalls), Caption: CountCaption},
+		{Title: "Syscall errors per second", Key: HistoryKey(HistoryTotal, TotalSyscallErrors), Caption: CountCaption},
+	}
+}
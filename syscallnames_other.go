@@ -0,0 +1,6 @@
+//go:build !linux || (!amd64 && !arm64)
+
+package main
+
+// SyscallNames is empty on architectures without a built-in syscall table, syscalls are shown by number instead.
+var SyscallNames = map[int]string{}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -10,16 +11,32 @@ import (
 )
 
 const (
-	MaxPanel = 4
+	PanelsPerRow = 2
+	// PanelHeight and MinPanelWidth are the least outer size of a panel in the layout.
+	PanelHeight       = 17
+	MinPanelWidth     = 72
+	MaxSparklineWidth = 20
+	ChartToggleKey    = "c"
+	SortKey           = "s"
+	FilterKey         = "/"
+	ZoomKey           = "z"
 )
 
 var (
 	FocusedBorderForeground = lipgloss.Color("228")
 	FocusedBorderBackground = lipgloss.Color("63")
+	selectedRowStyle        = lipgloss.NewStyle().Reverse(true)
+	filterLabel             = lipgloss.NewStyle().Foreground(lipgloss.Color("#d79921"))
+	warningLabel            = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#cd4439"))
 )
 
 type RefreshMessage time.Time
 
+// PanelSizeMessage is the outer size of a panel including its border, given to the panel by the layout.
+type PanelSizeMessage struct {
+	Width, Height int
+}
+
 func refreshAfter(interval time.Duration) tea.Cmd {
 	return tea.Tick(interval, func(t time.Time) tea.Msg { return RefreshMessage(t) })
 }
@@ -59,14 +76,190 @@ func IORateCaption(rate int) string {
 	}
 }
 
+// SparklineWidth is the room left for a sparkline in a row of a panel of the width that already has used characters.
+func SparklineWidth(panelWidth, used int) int {
+	return min(MaxSparklineWidth, max(0, panelWidth-2-used-1))
+}
+
+type Panel interface {
+	tea.Model
+	GetRegularStyle() lipgloss.Style
+	GetFocusedStyle() lipgloss.Style
+}
+
+// DetailPanel is a panel that opens a full-screen detail view of its selected row on enter.
+type DetailPanel interface {
+	// OpenDetail remembers the selected row for the detail view, it returns false if there is no row to show.
+	OpenDetail() bool
+	DetailView(width, height int) string
+}
+
+// RowCursor is the selected row of a list panel and the page of rows shown around it. The cursor follows the selected
+// row by its name as the rows are refreshed and sorted.
+type RowCursor struct {
+	Selected int
+	// Offset is the first row of the page.
+	Offset       int
+	pageSize     int
+	rowNames     []string
+	selectedName string
+	// Detail is the name of the row shown in the detail view.
+	Detail string
+}
+
+// SetRows clamps the cursor to the rows of the latest view and scrolls the page to keep the cursor on it, it returns
+// the range of the rows on the page.
+func (cursor *RowCursor) SetRows(rowNames []string, pageSize int) (int, int) {
+	cursor.rowNames = rowNames
+	cursor.pageSize = max(1, pageSize)
+	if i := slices.Index(rowNames, cursor.selectedName); i >= 0 {
+		cursor.Selected = i
+	}
+	cursor.Selected = max(0, min(cursor.Selected, len(rowNames)-1))
+	if len(rowNames) > 0 {
+		cursor.selectedName = rowNames[cursor.Selected]
+	}
+	cursor.Offset = max(min(cursor.Offset, cursor.Selected), cursor.Selected-cursor.pageSize+1)
+	cursor.Offset = max(0, min(cursor.Offset, len(rowNames)-cursor.pageSize))
+	return cursor.Offset, min(len(rowNames), cursor.Offset+cursor.pageSize)
+}
+
+func (cursor *RowCursor) Update(msg tea.KeyMsg) {
+	last := max(0, len(cursor.rowNames)-1)
+	switch msg.String() {
+	case tea.KeyUp.String(), "k":
+		cursor.Selected = max(0, cursor.Selected-1)
+	case tea.KeyDown.String(), "j":
+		cursor.Selected = min(cursor.Selected+1, last)
+	case tea.KeyPgUp.String():
+		cursor.Selected = max(0, cursor.Selected-cursor.pageSize)
+	case tea.KeyPgDown.String():
+		cursor.Selected = min(cursor.Selected+cursor.pageSize, last)
+	case tea.KeyHome.String(), "g":
+		cursor.Selected = 0
+	case tea.KeyEnd.String(), "G":
+		cursor.Selected = last
+	}
+	if cursor.Selected < len(cursor.rowNames) {
+		cursor.selectedName = cursor.rowNames[cursor.Selected]
+	}
+}
+
+func (cursor *RowCursor) OpenDetail() bool {
+	if cursor.Selected >= len(cursor.rowNames) {
+		return false
+	}
+	cursor.Detail = cursor.rowNames[cursor.Selected]
+	return true
+}
+
+// Render highlights the row if it is selected.
+func (cursor *RowCursor) Render(i int, row string) string {
+	if i == cursor.Selected {
+		return selectedRowStyle.Render(row)
+	}
+	return row
+}
+
+// PositionCaption tells the position of the cursor if the rows do not fit on a page, e.g. "3/40".
+func (cursor *RowCursor) PositionCaption() string {
+	if len(cursor.rowNames) <= cursor.pageSize {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", cursor.Selected+1, len(cursor.rowNames))
+}
+
+// SortOrder is the column a list panel is sorted by, the sort key cycles through the columns.
+type SortOrder struct {
+	Columns []string
+	Index   int
+}
+
+func NewSortOrder(columns ...string) SortOrder {
+	return SortOrder{Columns: columns}
+}
+
+func (order *SortOrder) Update(msg tea.KeyMsg) {
+	if msg.String() == SortKey {
+		order.Index = (order.Index + 1) % len(order.Columns)
+	}
+}
+
+func (order *SortOrder) Column() string {
+	return order.Columns[order.Index]
+}
+
+// ListHeader renders the title of a list panel with its sort order, filter, and the position of the cursor.
+func ListHeader(title string, order SortOrder, cursor RowCursor, filter RowFilter) string {
+	ret := genericLabel.Render(title + " - by " + order.Column())
+	if filter.Text != "" {
+		ret += " " + filterLabel.Render("["+filter.Text+"]")
+	}
+	return strings.TrimSpace(ret + " " + cursor.PositionCaption())
+}
+
+// FilterPanel is a panel whose rows can be narrowed down by the filter prompt.
+type FilterPanel interface {
+	GetFilter() *RowFilter
+}
+
+func ByteSizeCaption(size int) string {
+	if size >= 1024*1048576 {
+		return fmt.Sprintf("%dGB", size/1024/1048576)
+	} else if size >= 1048576 {
+		return fmt.Sprintf("%dMB", size/1048576)
+	} else if size >= 1024 {
+		return fmt.Sprintf("%dKB", size/1024)
+	} else {
+		return fmt.Sprintf("%dB", size)
+	}
+}
+
 type MainModel struct {
-	FocusIndex    int
-	ProcInfo      *ProcInfo
-	OverviewModel *OverviewModel
-	FileModel     *FileModel
-	NetModel      *NetModel
-	BlkdevModel   *BlkdevModel
-	BpfTracer     *BpfTracer
+	FocusIndex int
+	TermWidth  int
+	TermHeight int
+	// ChartMode shows the full-screen chart of the focused panel instead of all panels.
+	ChartMode bool
+	// DetailMode shows the full-screen detail view of the selected row of the focused panel.
+	DetailMode bool
+	// ZoomMode shows the focused panel over the whole terminal instead of all panels.
+	ZoomMode bool
+	// FilterPrompt takes the keys for the filter of the focused panel until it is applied or cancelled.
+	FilterPrompt   bool
+	filterText     string
+	filterError    string
+	History        *History
+	Layout         PanelLayout
+	ProcInfo       *ProcInfo
+	OverviewModel  *OverviewModel
+	FileModel      *FileModel
+	NetModel       *NetModel
+	BlkdevModel    *BlkdevModel
+	SyscallModel   *SyscallModel
+	EventModel     *EventModel
+	LifecycleModel *LifecycleModel
+	LockModel      *LockModel
+	ListenModel    *ListenModel
+	RequestModel   *RequestModel
+	BpfTracer      *BpfTracer
+}
+
+// Panels are the panels shown by the layout, in the order of the focus.
+func (model *MainModel) Panels() []Panel {
+	all := model.allPanels()
+	var ret []Panel
+	for _, row := range model.Layout {
+		for _, name := range row {
+			ret = append(ret, all[slices.Index(PanelNames, name)])
+		}
+	}
+	return ret
+}
+
+// allPanels are all the panels in the order of PanelNames, they are all updated whether they are shown or not.
+func (model *MainModel) allPanels() []Panel {
+	return []Panel{model.OverviewModel, model.FileModel, model.NetModel, model.BlkdevModel, model.SyscallModel, model.EventModel, model.LifecycleModel, model.LockModel, model.ListenModel, model.RequestModel}
 }
 
 func (model *MainModel) Init() tea.Cmd {
@@ -74,53 +267,191 @@ func (model *MainModel) Init() tea.Cmd {
 }
 
 func (model *MainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	panels := model.Panels()
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if model.FilterPrompt {
+			cmd := model.updateFilterPrompt(msg)
+			model.resizePanels()
+			return model, cmd
+		}
 		switch msg.String() {
 		case tea.KeyCtrlC.String(), "q":
 			return model, tea.Quit
+		case FilterKey:
+			if panel, ok := panels[model.FocusIndex].(FilterPanel); ok && !model.ChartMode && !model.DetailMode {
+				model.FilterPrompt = true
+				model.filterText = panel.GetFilter().Text
+				model.filterError = ""
+			}
+		case ChartToggleKey:
+			model.ChartMode = !model.ChartMode
+			model.DetailMode = false
+		case tea.KeyEnter.String():
+			if panel, ok := panels[model.FocusIndex].(DetailPanel); ok && !model.DetailMode {
+				model.DetailMode = panel.OpenDetail()
+				model.ChartMode = false
+			}
+		case ZoomKey:
+			model.ZoomMode = !model.ZoomMode
+			model.ChartMode = false
+			model.DetailMode = false
+		case tea.KeyEsc.String():
+			// Step back from the detail view or the chart to the panels first, and then from the zoomed panel.
+			if !model.DetailMode && !model.ChartMode {
+				model.ZoomMode = false
+			}
+			model.DetailMode = false
+			model.ChartMode = false
 		case tea.KeyTab.String():
 			model.FocusIndex++
-			if model.FocusIndex == MaxPanel {
+			if model.FocusIndex == len(panels) {
 				model.FocusIndex = 0
 			}
+			model.DetailMode = false
 		case tea.KeyShiftTab.String():
 			model.FocusIndex--
 			if model.FocusIndex == -1 {
-				model.FocusIndex = MaxPanel - 1
+				model.FocusIndex = len(panels) - 1
 			}
+			model.DetailMode = false
+		default:
+			// The other keys, such as the arrow keys, only concern the focused panel.
+			_, cmd := panels[model.FocusIndex].Update(msg)
+			return model, cmd
+		}
+		model.resizePanels()
+		return model, nil
+	case tea.WindowSizeMsg:
+		model.TermWidth = msg.Width
+		model.TermHeight = msg.Height
+		model.resizePanels()
+		return model, nil
+	}
+	var cmds []tea.Cmd
+	for _, panel := range model.allPanels() {
+		_, cmd := panel.Update(msg)
+		cmds = append(cmds, cmd)
+	}
+	return model, tea.Batch(cmds...)
+}
+
+// resizePanels gives the shown panels their size in the layout, or the whole terminal to the zoomed panel.
+func (model *MainModel) resizePanels() {
+	height := model.TermHeight - model.filterPromptHeight()
+	if model.ZoomMode {
+		model.Panels()[model.FocusIndex].Update(PanelSizeMessage{Width: model.TermWidth, Height: max(PanelHeight, height)})
+		return
+	}
+	for _, row := range model.arrangePanels(height) {
+		for _, box := range row {
+			box.Update(PanelSizeMessage{Width: box.Width, Height: box.Height})
 		}
 	}
-	_, overviewUpdate := model.OverviewModel.Update(msg)
-	_, fileUpdate := model.FileModel.Update(msg)
-	_, netUpdate := model.NetModel.Update(msg)
-	_, blkdevUpdate := model.BlkdevModel.Update(msg)
-	return model, tea.Batch(overviewUpdate, fileUpdate, netUpdate, blkdevUpdate)
+}
+
+// updateFilterPrompt edits the filter text, and applies it to the focused panel on enter.
+func (model *MainModel) updateFilterPrompt(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return tea.Quit
+	case tea.KeyEsc:
+		model.FilterPrompt = false
+	case tea.KeyEnter:
+		filter, err := ParseRowFilter(model.filterText)
+		if err != nil {
+			model.filterError = err.Error()
+			return nil
+		}
+		*model.Panels()[model.FocusIndex].(FilterPanel).GetFilter() = filter
+		model.FilterPrompt = false
+	case tea.KeyBackspace:
+		if runes := []rune(model.filterText); len(runes) > 0 {
+			model.filterText = string(runes[:len(runes)-1])
+		}
+	case tea.KeyCtrlU:
+		model.filterText = ""
+	case tea.KeySpace:
+		model.filterText += " "
+	case tea.KeyRunes:
+		model.filterText += string(msg.Runes)
+	}
+	return nil
 }
 
 func (model *MainModel) View() string {
 	model.ProcInfo.Mutex.RLock()
 	defer model.ProcInfo.Mutex.RUnlock()
 
-	overview := model.OverviewModel.GetRegularStyle().Render(model.OverviewModel.View())
-	if model.FocusIndex == 0 {
-		overview = model.OverviewModel.GetFocusedStyle().Render(model.OverviewModel.View())
+	// The panels do not have a size until the first window size message.
+	if model.TermWidth == 0 {
+		return ""
 	}
-	fileStats := model.FileModel.GetRegularStyle().Render(model.FileModel.View())
-	if model.FocusIndex == 1 {
-		fileStats = model.FileModel.GetFocusedStyle().Render(model.FileModel.View())
+	if model.ChartMode {
+		return model.chartView()
+	}
+	if model.DetailMode {
+		return model.detailView()
+	}
+	if model.ZoomMode {
+		panel := model.Panels()[model.FocusIndex]
+		return panel.GetFocusedStyle().Render(panel.View()) + model.filterPromptView()
+	}
+	height := model.TermHeight - model.filterPromptHeight()
+	var rows []string
+	var focusRow int
+	for i, row := range model.arrangePanels(height) {
+		var boxes []string
+		for _, box := range row {
+			if box.Index == model.FocusIndex {
+				boxes = append(boxes, box.GetFocusedStyle().Render(box.View()))
+				focusRow = i
+			} else {
+				boxes = append(boxes, box.GetRegularStyle().Render(box.View()))
+			}
+		}
+		rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Top, boxes...))
 	}
-	netStats := model.NetModel.GetRegularStyle().Render(model.NetModel.View())
-	if model.FocusIndex == 2 {
-		netStats = model.NetModel.GetFocusedStyle().Render(model.NetModel.View())
+	// Scroll the rows to keep the focused panel on screen when the terminal is too short to show all of them.
+	visibleRows := max(1, height/PanelHeight)
+	firstRow := max(0, focusRow-visibleRows+1)
+	return lipgloss.JoinVertical(lipgloss.Top, rows[firstRow:min(len(rows), firstRow+visibleRows)]...) + model.filterPromptView()
+}
+
+func (model *MainModel) filterPromptHeight() int {
+	if model.FilterPrompt {
+		return 1
 	}
-	blkdevStats := model.BlkdevModel.GetRegularStyle().Render(model.BlkdevModel.View())
-	if model.FocusIndex == 3 {
-		blkdevStats = model.BlkdevModel.GetFocusedStyle().Render(model.BlkdevModel.View())
+	return 0
+}
+
+func (model *MainModel) filterPromptView() string {
+	if !model.FilterPrompt {
+		return ""
 	}
+	return fmt.Sprintf("\n%s %s█ %s", genericLabel.Render("Filter:"), model.filterText, filterLabel.Render(model.filterError))
+}
 
-	return lipgloss.JoinVertical(lipgloss.Top,
-		lipgloss.JoinHorizontal(lipgloss.Left, overview, fileStats),
-		lipgloss.JoinHorizontal(lipgloss.Left, netStats, blkdevStats),
-	)
+// fullScreenStyle is the style of the chart and detail views, which take the whole terminal.
+func (model *MainModel) fullScreenStyle() lipgloss.Style {
+	return lipgloss.NewStyle().Width(model.TermWidth - 2).Height(model.TermHeight - 2).
+		BorderStyle(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color(FocusedBorderForeground)).
+		BorderBackground(lipgloss.Color(FocusedBorderBackground))
+}
+
+// detailView renders the selected row of the focused panel over the whole terminal.
+func (model *MainModel) detailView() string {
+	panel := model.Panels()[model.FocusIndex].(DetailPanel)
+	return model.fullScreenStyle().Render(panel.DetailView(model.TermWidth-2, model.TermHeight-4) + "\nPress esc to return")
+}
+
+// chartView renders the history of the focused panel over the whole terminal.
+func (model *MainModel) chartView() string {
+	var series []ChartSeries
+	if panel, ok := model.Panels()[model.FocusIndex].(ChartPanel); ok {
+		series = panel.ChartSeries()
+	}
+	footer := fmt.Sprintf("Last %v, press %s to return", time.Duration(model.History.Capacity*model.BpfTracer.SamplingIntervalSec)*time.Second, ChartToggleKey)
+	return model.fullScreenStyle().Render(model.History.RenderChart(series, model.TermWidth-2, model.TermHeight-4) + "\n" + footer)
 }
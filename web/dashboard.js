@@ -0,0 +1,194 @@
+'use strict';
+
+// The history of the session is kept in the page, one point per sampling interval.
+const maxHistory = 17280;
+const maxEvents = 200;
+const colors = ['#a6e3a1', '#89b4fa', '#f9e2af', '#f38ba8', '#cba6f7', '#94e2d5'];
+const history = [];
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+function escapeHTML(text) {
+  return String(text).replace(/[&<>"']/g, (c) => ({'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c]));
+}
+
+function rateCaption(bytesPerSec) {
+  const units = ['B/s', 'KB/s', 'MB/s', 'GB/s'];
+  let i = 0;
+  while (bytesPerSec >= 1024 && i < units.length - 1) {
+    bytesPerSec /= 1024;
+    i++;
+  }
+  return `${bytesPerSec.toFixed(i === 0 ? 0 : 1)} ${units[i]}`;
+}
+
+function durationCaption(nanos) {
+  if (nanos >= 1e6) {
+    return `${(nanos / 1e6).toFixed(1)}ms`;
+  }
+  return `${(nanos / 1e3).toFixed(0)}µs`;
+}
+
+function stateClass(state) {
+  return state === 'R' || state === 'S' ? `state-${state}` : 'state-other';
+}
+
+// chart renders the series of the history as an SVG line chart scaled to the largest value.
+function chart(element, series, caption) {
+  const width = 600;
+  const height = 120;
+  let maxValue = 1;
+  for (const s of series) {
+    for (const v of s.values) {
+      maxValue = Math.max(maxValue, v);
+    }
+  }
+  const points = Math.max(2, history.length);
+  let svg = `<svg viewBox="0 0 ${width} ${height}" preserveAspectRatio="none">`;
+  series.forEach((s, i) => {
+    const coords = s.values.map((v, x) => `${(x * width / (points - 1)).toFixed(1)},${(height - v * (height - 4) / maxValue).toFixed(1)}`);
+    svg += `<polyline fill="none" stroke-width="1.5" stroke="${colors[i % colors.length]}" points="${coords.join(' ')}"/>`;
+  });
+  svg += '</svg><div class="legend">';
+  series.forEach((s, i) => {
+    const latest = s.values.length ? s.values[s.values.length - 1] : 0;
+    svg += `<span style="color:${colors[i % colors.length]}">${escapeHTML(s.name)} ${escapeHTML(caption(latest))}</span>`;
+  });
+  element.innerHTML = svg + `<span>max ${escapeHTML(caption(maxValue))}</span></div>`;
+}
+
+function historySeries(name, pick) {
+  return {name: name, values: history.map(pick)};
+}
+
+function record(summary) {
+  const interval = summary.SamplingIntervalSec || 1;
+  const point = {fileRead: 0, fileWrite: 0, netIn: 0, netOut: 0, rss: summary.Process.RSSBytes, blkdevs: {}};
+  for (const file of summary.Files || []) {
+    point.fileRead += file.ReadBytes / interval;
+    point.fileWrite += file.WrittenBytes / interval;
+  }
+  for (const counter of summary.TCPDestinations || []) {
+    point.netIn += counter.ByteCounter / interval;
+  }
+  for (const counter of summary.TCPSources || []) {
+    point.netOut += counter.ByteCounter / interval;
+  }
+  for (const blkdev of summary.BlockDevices || []) {
+    point.blkdevs[blkdev.DeviceName] = blkdev.SectorCount * 512 / interval;
+  }
+  history.push(point);
+  if (history.length > maxHistory) {
+    history.shift();
+  }
+}
+
+function renderOverview(summary) {
+  const proc = summary.Process;
+  const since = Math.round((Date.parse(summary.Time) - Date.parse(proc.StartedAt)) / 1000);
+  $('title').textContent = `${proc.PID} ${proc.Comm}`;
+  let dl = '';
+  for (const [key, value] of [['Exe', proc.Exe], ['Cwd', proc.CWD], ['Since', `${since}s`], ['FDs', Object.keys(proc.FDs || {}).length]]) {
+    dl += `<dt>${key}</dt><dd>${escapeHTML(value)}</dd>`;
+  }
+  for (const [key, value] of Object.entries(proc.Identity || {})) {
+    dl += `<dt>${escapeHTML(key)}</dt><dd>${escapeHTML(value)}</dd>`;
+  }
+  $('process').innerHTML = dl;
+  $('hierarchy').innerHTML = (summary.Hierarchy || []).map((member, depth) =>
+    `<li>${'&nbsp;'.repeat(depth * 2)}${depth ? '└ ' : ''}${escapeHTML(member.Role)} ` +
+    `<span class="${stateClass(member.State)}">${escapeHTML(member.State)}</span> ` +
+    `${member.PID} ${escapeHTML(member.Comm)} (${member.UID}:${member.GID})</li>`).join('');
+  $('threads').innerHTML = 'Threads: ' + Object.entries(summary.ThreadStates || {}).map(([state, count]) =>
+    `<span class="${stateClass(state)}">${count} ${escapeHTML(state)}</span>`).join(' ');
+  chart($('rss-chart'), [historySeries('RSS', (p) => p.rss)], (v) => rateCaption(v).replace('/s', ''));
+}
+
+function renderFiles(summary) {
+  const interval = summary.SamplingIntervalSec || 1;
+  chart($('file-chart'), [historySeries('read', (p) => p.fileRead), historySeries('written', (p) => p.fileWrite)], rateCaption);
+  const files = summary.Files || [];
+  $('file-table').innerHTML = files.length === 0 ? '<tr><td>No data yet.</td></tr>' : files.slice(0, 12).map((file) =>
+    `<tr><td title="${escapeHTML(file.Name)}">${escapeHTML(file.Name)}</td>` +
+    `<td>${rateCaption(file.ReadBytes / interval)}</td><td>${rateCaption(file.WrittenBytes / interval)}</td></tr>`).join('');
+}
+
+function renderNet(summary) {
+  const interval = summary.SamplingIntervalSec || 1;
+  chart($('net-chart'), [historySeries('incoming', (p) => p.netIn), historySeries('outgoing', (p) => p.netOut)], rateCaption);
+  const rows = (counters) => counters.length === 0 ? '<tr><td>No data yet.</td></tr>' : counters.slice(0, 8).map((counter) =>
+    `<tr><td>${escapeHTML(counter.IP)}</td><td>${counter.Port}</td><td>${rateCaption(counter.ByteCounter / interval)}</td></tr>`).join('');
+  $('net-in-table').innerHTML = rows(summary.TCPDestinations || []);
+  $('net-out-table').innerHTML = rows(summary.TCPSources || []);
+}
+
+function renderBlkdev(summary) {
+  const interval = summary.SamplingIntervalSec || 1;
+  const devices = new Set();
+  for (const point of history) {
+    Object.keys(point.blkdevs).forEach((name) => devices.add(name));
+  }
+  chart($('blkdev-chart'), [...devices].map((name) => historySeries(name, (p) => p.blkdevs[name] || 0)), rateCaption);
+  const blkdevs = summary.BlockDevices || [];
+  const op = (blkdev, name) => (blkdev.ByOp || {})[name] || {Ops: 0, SectorCount: 0, QueueTime: 0, ServiceTime: 0};
+  const avg = (total, ops) => durationCaption(ops ? total / ops : 0);
+  $('blkdev-table').innerHTML = blkdevs.length === 0 ? '<tr><td>No data yet.</td></tr>' : blkdevs.map((blkdev) => {
+    const read = op(blkdev, 'read');
+    const write = op(blkdev, 'write');
+    return `<tr><td>${escapeHTML(blkdev.DeviceName)}</td>` +
+      `<td>${rateCaption(read.SectorCount * 512 / interval)}</td><td>${rateCaption(write.SectorCount * 512 / interval)}</td>` +
+      `<td>${avg(read.QueueTime, read.Ops)} / ${avg(write.QueueTime, write.Ops)}</td>` +
+      `<td>${avg(read.ServiceTime, read.Ops)} / ${avg(write.ServiceTime, write.Ops)}</td></tr>`;
+  }).join('');
+}
+
+function render(summary) {
+  record(summary);
+  renderOverview(summary);
+  renderFiles(summary);
+  renderNet(summary);
+  renderBlkdev(summary);
+}
+
+function addEvent(text, isError) {
+  const list = $('event-list');
+  const item = document.createElement('li');
+  item.textContent = text;
+  if (isError) {
+    item.className = 'error';
+  }
+  list.prepend(item);
+  while (list.children.length > maxEvents) {
+    list.lastChild.remove();
+  }
+}
+
+function timeCaption(time) {
+  return new Date(time).toLocaleTimeString();
+}
+
+function connect() {
+  const source = new EventSource('api/events');
+  source.onopen = () => {
+    $('status').textContent = 'live';
+    $('status').className = 'status live';
+  };
+  source.onerror = () => {
+    $('status').textContent = 'reconnecting';
+    $('status').className = 'status';
+  };
+  source.addEventListener('summary', (e) => render(JSON.parse(e.data)));
+  source.addEventListener('syscall', (e) => {
+    const evt = JSON.parse(e.data);
+    const target = evt.Path || evt.Address || evt.Args.map((arg) => `0x${arg.toString(16)}`).join(', ');
+    addEvent(`${timeCaption(evt.Time)} ${evt.TID} ${evt.Name}(${target}) = ${evt.Ret} <${durationCaption(evt.Duration)}>`, evt.Ret < 0);
+  });
+  source.addEventListener('lifecycle', (e) => {
+    const evt = JSON.parse(e.data);
+    addEvent(`${timeCaption(evt.Time)} ${evt.Kind} ${evt.PID} ${evt.Comm} signal ${evt.Signal} code ${evt.Code}`, ['oom_kill', 'core_dump'].includes(evt.Kind) || (evt.Kind === 'exit' && evt.Signal !== 0));
+  });
+}
+
+fetch('api/summary').then((resp) => resp.json()).then(render).finally(connect);